@@ -4,45 +4,69 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Application state
 type Application struct {
-	Name            string
-	Version         string
-	Commands        []string
-	RequestMethods  []string
-	Args            []string
-	StartTime       time.Time
-	EndTime         time.Time
-	Duration        time.Duration
-	Mode            string
-	HistoryMode     string
-	HistoryRecordId int
-	HistoryPath     string
-	InputFilePath   string
-	OutputFilePath  string
-	Request         Request
-	Response        Response
+	Id               string
+	ReplayOf         string
+	Name             string
+	Version          string
+	Commands         []string
+	RequestMethods   []string
+	Args             []string
+	StartTime        time.Time
+	EndTime          time.Time
+	Duration         time.Duration
+	Mode             string
+	HistoryMode      string
+	HistoryRecordId  int
+	HistoryPath      string
+	CredentialsPath  string
+	SessionsPath     string
+	CollectionsPath  string
+	EnvironmentsPath string
+	ConfigPath       string
+	InputFilePath    string
+	OutputFilePath   string
+	Request          Request
+	Response         Response
+	session          *Session
+	cookieJar        http.CookieJar
+	sessionMutex     *sync.Mutex
+	history          HistoryStore
+	redactor         *historyRedactor
+	config           Config
 }
 
 // Single-call entry point
 func Start() error {
 	home := os.Getenv("HOME")
 	historyPath := path.Join(home, ".gohttp/history")
+	credentialsPath := path.Join(home, ".gohttp/credentials")
+	sessionsPath := path.Join(home, ".gohttp/sessions")
+	collectionsPath := path.Join(home, ".gohttp/collections")
+	environmentsPath := path.Join(home, ".gohttp/environments")
+	configPath := path.Join(home, ".gohttp/config.json")
 
 	app := &Application{
-		Name:           "gohttp",
-		Version:        "0.1.1",
-		Commands:       []string{"help", "version", "history"},
-		RequestMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
-		Args:           os.Args[1:],
-		HistoryPath:    historyPath,
+		Name:             "gohttp",
+		Version:          "0.1.1",
+		Commands:         []string{"help", "version", "history", "import", "save", "run"},
+		RequestMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		Args:             os.Args[1:],
+		HistoryPath:      historyPath,
+		CredentialsPath:  credentialsPath,
+		SessionsPath:     sessionsPath,
+		CollectionsPath:  collectionsPath,
+		EnvironmentsPath: environmentsPath,
+		ConfigPath:       configPath,
 	}
 
 	err := app.Run()
@@ -63,6 +87,21 @@ func (app *Application) Run() error {
 		return err
 	}
 
+	err = app.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	app.redactor, err = newHistoryRedactor(app.config.Redaction)
+	if err != nil {
+		return err
+	}
+
+	app.history, err = newLogHistoryStore(app.HistoryPath, app.redactor, app.config.Encryption)
+	if err != nil {
+		return err
+	}
+
 	err = app.DetermineMode()
 	if err != nil {
 		return err
@@ -77,6 +116,21 @@ func (app *Application) Run() error {
 		if err != nil {
 			return err
 		}
+	} else if app.Mode == "import" {
+		err := app.RunImport()
+		if err != nil {
+			return err
+		}
+	} else if app.Mode == "save" {
+		err := app.RunSave()
+		if err != nil {
+			return err
+		}
+	} else if app.Mode == "run" {
+		err := app.RunSaved()
+		if err != nil {
+			return err
+		}
 	} else if app.Mode == "http" {
 		err := app.RunHttp()
 		if err != nil {
@@ -96,12 +150,32 @@ func (app *Application) SetupAppDirs() error {
 	if err != nil {
 		return errors.New("Failed to create directory " + app.HistoryPath + "\n" + err.Error())
 	}
+
+	err = os.MkdirAll(app.CredentialsPath, 0700)
+	if err != nil {
+		return errors.New("Failed to create directory " + app.CredentialsPath + "\n" + err.Error())
+	}
+
+	err = os.MkdirAll(app.SessionsPath, 0700)
+	if err != nil {
+		return errors.New("Failed to create directory " + app.SessionsPath + "\n" + err.Error())
+	}
+
+	err = os.MkdirAll(app.CollectionsPath, 0700)
+	if err != nil {
+		return errors.New("Failed to create directory " + app.CollectionsPath + "\n" + err.Error())
+	}
+
+	err = os.MkdirAll(app.EnvironmentsPath, 0700)
+	if err != nil {
+		return errors.New("Failed to create directory " + app.EnvironmentsPath + "\n" + err.Error())
+	}
 	return nil
 }
 
 // Determine desired operation
 func (app *Application) DetermineMode() error {
-	if len(app.Args) < 1 {
+	if len(app.Args) < 1 || app.Args[0] == "-h" || app.Args[0] == "--help" {
 		app.Mode = "help"
 	} else {
 		for i, j := 0, len(app.Commands); i < j; i++ {
@@ -124,85 +198,141 @@ func (app *Application) RunVersion() error {
 	return nil
 }
 
-// Print help text to console
+// Print help text to console, generated from each command's options
 func (app *Application) RunHelp() error {
+	historySets := make([]*OptionSet, len(historyModes))
+	for i, j := 0, len(historyModes); i < j; i++ {
+		historySets[i] = newHistoryOptionSet(historyModes[i])
+	}
+	importSet := newImportOptionSet()
+	runSet := newRunOptionSet()
+	httpSet := newHttpOptionSet()
+
 	fmt.Println("Usage:")
 	fmt.Println("	gohttp COMMAND OPTIONS")
 	fmt.Println("")
 	fmt.Println("Commands:")
-	fmt.Println("	[help]")
-	fmt.Println("	version")
-	fmt.Println("	history")
+	fmt.Println("	[" + app.Commands[0] + "]")
+	for i, j := 1, len(app.Commands); i < j; i++ {
+		fmt.Println("	" + app.Commands[i])
+	}
 	fmt.Println("	[REQUESTMETHOD] URL")
 	fmt.Println("")
 	fmt.Println("History commands:")
-	fmt.Println("	history [list] FLAGS")
-	fmt.Println("	history detail 1")
-	fmt.Println("	history replay 1")
-	fmt.Println("	history save 1 /path/to/output/file.json")
+	for i, j := 0, len(historySets); i < j; i++ {
+		fmt.Println("	" + historySets[i].Usage)
+	}
 	fmt.Println("")
-	fmt.Println("HTTP Commands:")
-	fmt.Println("	[get] URL FLAGS")
-	fmt.Println("	head URL FLAGS")
-	fmt.Println("	post URL FLAGS")
-	fmt.Println("	put URL FLAGS")
-	fmt.Println("	patch URL FLAGS")
-	fmt.Println("	delete URL FLAGS")
+	fmt.Println("Import commands:")
+	fmt.Println("	" + importSet.Usage)
+	fmt.Println("")
+	fmt.Println("Collection commands:")
+	fmt.Println("	save NAME [REQUESTMETHOD] URL FLAGS")
+	fmt.Println("	" + runSet.Usage)
 	fmt.Println("")
-	fmt.Println("History Flags:")
-	fmt.Println("	(-f | --find) GET")
-	fmt.Println("	(-i | --insensitive)")
-	fmt.Println("	(-l | --limit) 10")
-	fmt.Println("	(-s | --skip) 10")
+	fmt.Println("HTTP Commands:")
+	for i, j := 0, len(app.RequestMethods); i < j; i++ {
+		method := strings.ToLower(app.RequestMethods[i])
+		if i == 0 {
+			method = "[" + method + "]"
+		}
+		fmt.Println("	" + method + " URL FLAGS")
+	}
 	fmt.Println("")
-	fmt.Println("HTTP Flags:")
-	fmt.Println("	(-j | --json)")
-	fmt.Println("	(-c | --content-type) application/json")
-	fmt.Println("	(-a | --accept) application/json")
-	fmt.Println("	(-t | --timeout) 0 - 4294967295")
-	fmt.Println("	(-i | --input) /path/to/input/file.json")
-	fmt.Println("	(-o | --output) /path/to/output/file.json")
-	fmt.Println("	(-d | --data) '{\"key\": \"value\"}'")
-	fmt.Println("	(-p | --print)")
+
+	optionSets := append(historySets, importSet, runSet, httpSet)
+	for i, j := 0, len(optionSets); i < j; i++ {
+		if len(optionSets[i].Options) > 0 {
+			fmt.Println(optionSets[i].Title + " Flags:")
+			optionSets[i].PrintOptions()
+			fmt.Println("")
+		}
+	}
+
+	fmt.Println("Exit codes:")
+	for i, j := 0, len(exitCodeDescriptions); i < j; i++ {
+		fmt.Printf("	%d  %s\n", exitCodeDescriptions[i].Code, exitCodeDescriptions[i].Description)
+	}
 	fmt.Println("")
 	return nil
 }
 
 // Determine history mode
 func (app *Application) RunHistory() error {
-	historyModeMap := map[string]bool{
-		"list":   true,
-		"detail": true,
-		"replay": true,
-		"save":   true,
-	}
-
-	app.HistoryMode = "list"
-	if len(app.Args) > 1 {
-		lowerArg := strings.ToLower(app.Args[1])
-		if _, present := historyModeMap[lowerArg]; present {
-			app.HistoryMode = lowerArg
+	app.HistoryMode = historyModes[0]
+	args := app.Args[1:]
+	if len(args) > 0 {
+		lowerArg := strings.ToLower(args[0])
+		for i, j := 0, len(historyModes); i < j; i++ {
+			if lowerArg == historyModes[i] {
+				app.HistoryMode = lowerArg
+				args = args[1:]
+				break
+			}
 		}
 	}
 
+	opts := newHistoryOptionSet(app.HistoryMode)
+	err := opts.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Keep secrets out of the arguments saved to history
+	app.Args = append(append([]string{}, app.Args[:len(app.Args)-len(args)]...), app.redactor.redactArgs(opts, args)...)
+
 	if app.HistoryMode == "detail" {
-		err := app.RunHistoryDetail()
+		err := app.RunHistoryDetail(opts)
 		if err != nil {
 			return err
 		}
 	} else if app.HistoryMode == "replay" {
-		err := app.RunHistoryReplay()
+		err := app.RunHistoryReplay(opts)
 		if err != nil {
 			return err
 		}
 	} else if app.HistoryMode == "save" {
-		err := app.RunHistorySave()
+		err := app.RunHistorySave(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "export" {
+		err := app.RunHistoryExport(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "name" {
+		err := app.RunHistoryName(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "diff" {
+		err := app.RunHistoryDiff(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "delete" {
+		err := app.RunHistoryDelete(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "clear" {
+		err := app.RunHistoryClear(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "prune" {
+		err := app.RunHistoryPrune(opts)
+		if err != nil {
+			return err
+		}
+	} else if app.HistoryMode == "rekey" {
+		err := app.RunHistoryRekey(opts)
 		if err != nil {
 			return err
 		}
 	} else {
 		// Default to list
-		err := app.RunHistoryList()
+		err := app.RunHistoryList(opts)
 		if err != nil {
 			return err
 		}
@@ -228,18 +358,27 @@ func (app *Application) RunHttp() error {
 		return err
 	}
 
-	return nil
+	return app.checkResponseStatus()
 }
 
-// Save app to json file
+// Save app to history
 func (app *Application) SaveApp() error {
 	endTime := time.Now()
 	duration := endTime.Sub(app.StartTime)
 	app.EndTime = endTime
 	app.Duration = duration
 
-	fileName := app.getFileName()
-	err := app.saveJson(app.HistoryPath, fileName, app)
+	err := app.storeCredential(app.Request.Auth)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.history.Append(app)
+	if err != nil {
+		return err
+	}
+
+	err = app.enforceRetention()
 	if err != nil {
 		return err
 	}
@@ -251,50 +390,6 @@ func (app *Application) SaveApp() error {
 //	Private functions
 //
 
-// Form history filename
-func (app *Application) getFileName() string {
-	cleanTime := strings.Replace(app.StartTime.String()[:19], ":", "_", -1)
-	cleanTime = strings.Replace(cleanTime, " ", "_", -1)
-	cleanTime = strings.Replace(cleanTime, "-", "_", -1)
-	fileNameSlice := []string{cleanTime, "__", app.Request.Method, "__", cleanUrl(app.Request.URL.String())}
-	fileName := strings.Join(fileNameSlice, "")
-	if len(fileName) > 196 {
-		fileName = fileName[:196]
-	}
-	return fileName + ".json"
-}
-
-// Clean URL for file name
-func cleanUrl(url string) string {
-	re := regexp.MustCompile("[^a-zA-Z0-9_]")
-	cleanUrl := re.ReplaceAllString(url, "_")
-	re = regexp.MustCompile("_+")
-	return re.ReplaceAllString(cleanUrl, "_")
-}
-
-// Determine if flag is active from command line args
-func (app *Application) flagIsActive(flagMap map[string]bool) bool {
-	flagIsActive := false
-	for i, j := 0, len(app.Args); i < j; i++ {
-		if _, present := flagMap[app.Args[i]]; present {
-			flagIsActive = true
-		}
-	}
-	return flagIsActive
-}
-
-// Get value for command line option
-func (app *Application) getOption(optMap map[string]bool, defaultValue string) string {
-	optValue := defaultValue
-	for i, j := 0, len(app.Args); i < j; i++ {
-		if _, present := optMap[app.Args[i]]; present && len(app.Args) > i {
-			optValue = app.Args[i+1]
-			break
-		}
-	}
-	return optValue
-}
-
 // Save object to a file
 func (app *Application) saveJson(savePath string, fileName string, v interface{}) error {
 	jsonBytes, err := json.Marshal(v)
@@ -0,0 +1,278 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Placeholder written to disk in place of secret values
+const redactedValue = "[redacted]"
+
+// Credentials for authenticating a request
+type Auth struct {
+	Type         string
+	Username     string
+	Password     string
+	Token        string
+	CredentialId string
+}
+
+// Marshal auth with secrets redacted; the secrets live in the credential store
+func (auth Auth) MarshalJSON() ([]byte, error) {
+	type plainAuth Auth
+	redacted := plainAuth(auth)
+	if redacted.Password != "" {
+		redacted.Password = redactedValue
+	}
+	if redacted.Token != "" {
+		redacted.Token = redactedValue
+	}
+	return json.Marshal(redacted)
+}
+
+//
+//	Private functions
+//
+
+// Build auth from command line options
+func newAuth(authOpt string, authType string, bearerOpt string) (*Auth, error) {
+	if authOpt != "" && bearerOpt != "" {
+		return nil, errors.New("Only one of --auth and --bearer may be given.")
+	}
+
+	if bearerOpt != "" {
+		return &Auth{Type: "bearer", Token: bearerOpt}, nil
+	} else if authOpt != "" {
+		authType = strings.ToLower(authType)
+		if authType != "basic" && authType != "digest" {
+			return nil, errors.New("Invalid auth type '" + authType + "'. Expected basic or digest.")
+		}
+
+		parts := strings.SplitN(authOpt, ":", 2)
+		if len(parts) < 2 {
+			return nil, errors.New("Invalid --auth value. Expected format is 'USER:PASS'.")
+		}
+		return &Auth{Type: authType, Username: parts[0], Password: parts[1]}, nil
+	}
+
+	return nil, nil
+}
+
+// Add credentials to request headers, except digest which needs a server challenge first
+func (auth *Auth) apply(header http.Header) {
+	if auth.Type == "basic" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	} else if auth.Type == "bearer" {
+		header.Set("Authorization", "Bearer "+auth.Token)
+	}
+}
+
+// Build the Authorization header answering a digest challenge
+func (auth *Auth) digestAuthorization(req *http.Request, challenge string, body []byte) (string, error) {
+	params := parseAuthParams(challenge)
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	var newHash func() hash.Hash
+	upperAlgorithm := strings.ToUpper(algorithm)
+	if upperAlgorithm == "MD5" || upperAlgorithm == "MD5-SESS" {
+		newHash = md5.New
+	} else if upperAlgorithm == "SHA-256" || upperAlgorithm == "SHA-256-SESS" {
+		newHash = sha256.New
+	} else {
+		return "", errors.New("Unsupported digest algorithm: " + algorithm)
+	}
+	digest := func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 16)
+	_, err := rand.Read(cnonceBytes)
+	if err != nil {
+		return "", errors.New("Error generating digest cnonce: " + err.Error())
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nonceCount := "00000001"
+	nonce := params["nonce"]
+	uri := req.URL.RequestURI()
+
+	ha1 := digest(auth.Username + ":" + params["realm"] + ":" + auth.Password)
+	if strings.HasSuffix(upperAlgorithm, "-SESS") {
+		ha1 = digest(ha1 + ":" + nonce + ":" + cnonce)
+	}
+
+	qop := ""
+	qopOptions := strings.Split(params["qop"], ",")
+	for i, j := 0, len(qopOptions); i < j; i++ {
+		option := strings.TrimSpace(qopOptions[i])
+		if option == "auth" {
+			qop = option
+			break
+		} else if option == "auth-int" {
+			qop = option
+		}
+	}
+
+	ha2 := digest(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		ha2 = digest(req.Method + ":" + uri + ":" + digest(string(body)))
+	}
+
+	response := ""
+	if qop == "" {
+		response = digest(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = digest(ha1 + ":" + nonce + ":" + nonceCount + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	fields := []string{
+		`username="` + auth.Username + `"`,
+		`realm="` + params["realm"] + `"`,
+		`nonce="` + nonce + `"`,
+		`uri="` + uri + `"`,
+		`algorithm=` + algorithm,
+		`response="` + response + `"`,
+	}
+	if qop != "" {
+		fields = append(fields, `qop=`+qop, `nc=`+nonceCount, `cnonce="`+cnonce+`"`)
+	}
+	if opaque, present := params["opaque"]; present {
+		fields = append(fields, `opaque="`+opaque+`"`)
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// Parse the comma separated key=value parameters of an auth challenge
+func parseAuthParams(challenge string) map[string]string {
+	params := make(map[string]string)
+	if space := strings.Index(challenge, " "); space > -1 {
+		challenge = challenge[space+1:]
+	}
+
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, " ,")
+		eq := strings.Index(challenge, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:eq]))
+		challenge = challenge[eq+1:]
+
+		value := ""
+		if strings.HasPrefix(challenge, `"`) {
+			end := 1
+			for end < len(challenge) && challenge[end] != '"' {
+				if challenge[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.Replace(challenge[1:end], `\`, "", -1)
+			if end < len(challenge) {
+				end++
+			}
+			challenge = challenge[end:]
+		} else {
+			end := strings.Index(challenge, ",")
+			if end < 0 {
+				end = len(challenge)
+			}
+			value = strings.TrimSpace(challenge[:end])
+			challenge = challenge[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// Save secrets to the credential store so history only holds a reference to them
+func (app *Application) storeCredential(auth *Auth) error {
+	if auth == nil || (auth.Password == "" && auth.Token == "") || auth.CredentialId != "" {
+		return nil
+	}
+
+	key, err := app.credentialKey()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(auth.Type + "\x00" + auth.Username + "\x00" + auth.Password + "\x00" + auth.Token))
+	auth.CredentialId = hex.EncodeToString(mac.Sum(nil))[:32]
+
+	type plainAuth Auth
+	jsonBytes, err := json.Marshal(plainAuth(*auth))
+	if err != nil {
+		return errors.New("Error creating credential json: " + err.Error())
+	}
+
+	fileName := auth.CredentialId + ".json"
+	err = ioutil.WriteFile(path.Join(app.CredentialsPath, fileName), jsonBytes, 0600)
+	if err != nil {
+		return errors.New("Error writing credential file " + fileName + ": " + err.Error())
+	}
+	return nil
+}
+
+// Restore secrets for a redacted auth from the credential store
+func (app *Application) loadCredential(auth *Auth) error {
+	if auth == nil || auth.CredentialId == "" {
+		return nil
+	}
+
+	fileName := auth.CredentialId + ".json"
+	jsonBytes, err := ioutil.ReadFile(path.Join(app.CredentialsPath, path.Base(fileName)))
+	if os.IsNotExist(err) {
+		return errors.New("Stored credentials " + auth.CredentialId + " are no longer available.")
+	} else if err != nil {
+		return errors.New("Error reading credential file " + fileName + ": " + err.Error())
+	}
+
+	type plainAuth Auth
+	stored := plainAuth{}
+	err = json.Unmarshal(jsonBytes, &stored)
+	if err != nil {
+		return errors.New("Error unmarshalling credential json: " + err.Error())
+	}
+	auth.Password = stored.Password
+	auth.Token = stored.Token
+	return nil
+}
+
+// Load or create the local key used to derive credential ids
+func (app *Application) credentialKey() ([]byte, error) {
+	keyPath := path.Join(app.CredentialsPath, ".key")
+	key, err := ioutil.ReadFile(keyPath)
+	if err == nil {
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.New("Error reading credential key: " + err.Error())
+	}
+
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, errors.New("Error generating credential key: " + err.Error())
+	}
+	err = ioutil.WriteFile(keyPath, key, 0600)
+	if err != nil {
+		return nil, errors.New("Error writing credential key: " + err.Error())
+	}
+	return key, nil
+}
@@ -0,0 +1,276 @@
+package application
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// Bodies at least this long are stored as blobs instead of inside records
+const blobMinSize = 256
+
+// Prefix of encrypted blobs; other blobs are plain gzip
+const encryptedBlobPrefix = "gohttp-encrypted-1\n"
+
+// Matches blob names, which are hex SHA-256 hashes
+var blobNameRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+//
+//	Private functions
+//
+
+// Move a record's bodies into blobs, or drop them when bodies are not stored.
+// Returns the stored size of the blobs.
+func (store *logHistoryStore) storeBodies(historyApp *Application, historyCipher *historyCipher) (int64, error) {
+	request := &historyApp.Request
+	response := &historyApp.Response
+	request.BodyBlob, response.BodyBlob = "", ""
+	if request.NoHistoryBody {
+		request.Body, response.Body = nil, nil
+		if request.Template != nil {
+			request.Template.Body = ""
+		}
+		return 0, nil
+	}
+
+	var blobBytes int64
+	if len(request.Body) >= blobMinSize {
+		name, size, err := store.writeBlob(historyCipher, request.Body)
+		if err != nil {
+			return blobBytes, err
+		}
+		request.Body, request.BodyBlob = nil, name
+		blobBytes += size
+	}
+	if len(response.Body) >= blobMinSize {
+		name, size, err := store.writeBlob(historyCipher, response.Body)
+		if err != nil {
+			return blobBytes, err
+		}
+		response.Body, response.BodyBlob = nil, name
+		blobBytes += size
+	}
+	return blobBytes, nil
+}
+
+// Read a record's bodies back from blobs
+func (store *logHistoryStore) loadBodies(historyApp *Application) error {
+	var err error
+	if historyApp.Request.BodyBlob != "" {
+		historyApp.Request.Body, err = store.readBlob(historyApp.Request.BodyBlob)
+		if err != nil {
+			return err
+		}
+	}
+	if historyApp.Response.BodyBlob != "" {
+		historyApp.Response.Body, err = store.readBlob(historyApp.Response.BodyBlob)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save data as a compressed blob named for its content, returning the name and stored size.
+// Identical data is only stored once.
+func (store *logHistoryStore) writeBlob(historyCipher *historyCipher, data []byte) (string, int64, error) {
+	name := blobName(historyCipher, data)
+	blobPath := store.blobPath(name)
+
+	blobInfo, err := os.Stat(blobPath)
+	if err == nil {
+		// Mark the blob as in use, so garbage collection keeps it
+		now := time.Now()
+		os.Chtimes(blobPath, now, now)
+		return name, blobInfo.Size(), nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return name, 0, errors.New("Error compressing history body: " + err.Error())
+	}
+
+	blobBytes := compressed.Bytes()
+	if historyCipher != nil {
+		sealed, err := historyCipher.sealRaw(blobBytes)
+		if err != nil {
+			return name, 0, err
+		}
+		blobBytes = append([]byte(encryptedBlobPrefix), sealed...)
+	}
+
+	err = os.MkdirAll(path.Dir(blobPath), 0777)
+	if err != nil {
+		return name, 0, errors.New("Failed to create directory " + path.Dir(blobPath) + "\n" + err.Error())
+	}
+
+	// Write to a temporary file first, so a blob is never seen partly written
+	tempFile, err := ioutil.TempFile(path.Dir(blobPath), name+".*.tmp")
+	if err != nil {
+		return name, 0, errors.New("Error writing history body: " + err.Error())
+	}
+	_, err = tempFile.Write(blobBytes)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), blobPath)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return name, 0, errors.New("Error writing history body: " + err.Error())
+	}
+	return name, int64(len(blobBytes)), nil
+}
+
+// Read and decompress a blob, decrypting it if it is encrypted
+func (store *logHistoryStore) readBlob(name string) ([]byte, error) {
+	if !blobNameRegexp.MatchString(name) {
+		return nil, errors.New("Invalid history body reference: " + name)
+	}
+
+	blobBytes, err := ioutil.ReadFile(store.blobPath(name))
+	if err != nil {
+		return nil, errors.New("Error reading history body: " + err.Error())
+	}
+
+	if bytes.HasPrefix(blobBytes, []byte(encryptedBlobPrefix)) {
+		historyCipher, err := store.loadCipher()
+		if err != nil {
+			return nil, err
+		} else if historyCipher == nil {
+			return nil, errors.New("History is encrypted, but its encryption metadata is missing.")
+		}
+
+		blobBytes, err = historyCipher.openRaw(blobBytes[len(encryptedBlobPrefix):])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(blobBytes))
+	if err != nil {
+		return nil, errors.New("Error decompressing history body: " + err.Error())
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.New("Error decompressing history body: " + err.Error())
+	}
+	return data, nil
+}
+
+// Remove blobs that no index entry references
+func (store *logHistoryStore) collectBlobs() error {
+	entries, err := store.readIndex()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool)
+	for i, j := 0, len(entries); i < j; i++ {
+		for k, l := 0, len(entries[i].Blobs); k < l; k++ {
+			referenced[entries[i].Blobs[k]] = true
+		}
+	}
+
+	blobsPath := path.Join(store.dirPath, "blobs")
+	dirInfos, err := ioutil.ReadDir(blobsPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Error reading history bodies: " + err.Error())
+	}
+
+	for i, j := 0, len(dirInfos); i < j; i++ {
+		if !dirInfos[i].IsDir() {
+			continue
+		}
+		dirPath := path.Join(blobsPath, dirInfos[i].Name())
+		fileInfos, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			return errors.New("Error reading history bodies: " + err.Error())
+		}
+
+		for k, l := 0, len(fileInfos); k < l; k++ {
+			if !referenced[fileInfos[k].Name()] {
+				err = os.Remove(path.Join(dirPath, fileInfos[k].Name()))
+				if err != nil && !os.IsNotExist(err) {
+					return errors.New("Error removing history body: " + err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Total stored size of all blobs
+func (store *logHistoryStore) blobsSize() (int64, error) {
+	var size int64
+	blobsPath := path.Join(store.dirPath, "blobs")
+	dirInfos, err := ioutil.ReadDir(blobsPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.New("Error reading history bodies: " + err.Error())
+	}
+
+	for i, j := 0, len(dirInfos); i < j; i++ {
+		if !dirInfos[i].IsDir() {
+			continue
+		}
+		fileInfos, err := ioutil.ReadDir(path.Join(blobsPath, dirInfos[i].Name()))
+		if err != nil {
+			return 0, errors.New("Error reading history bodies: " + err.Error())
+		}
+		for k, l := 0, len(fileInfos); k < l; k++ {
+			size += fileInfos[k].Size()
+		}
+	}
+	return size, nil
+}
+
+// Path of a blob, spread over subdirectories by its first characters
+func (store *logHistoryStore) blobPath(name string) string {
+	return path.Join(store.dirPath, "blobs", name[:2], name)
+}
+
+// Name a blob for its content. Encrypted history uses a keyed hash,
+// so names do not reveal which content is stored.
+func blobName(historyCipher *historyCipher, data []byte) string {
+	if historyCipher == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, historyCipher.blobKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Names of the blobs a record references
+func recordBlobs(historyApp *Application) []string {
+	blobs := make([]string, 0, 2)
+	if historyApp.Request.BodyBlob != "" {
+		blobs = append(blobs, historyApp.Request.BodyBlob)
+	}
+	if historyApp.Response.BodyBlob != "" {
+		blobs = append(blobs, historyApp.Response.BodyBlob)
+	}
+	return blobs
+}
@@ -0,0 +1,186 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body encodings in saved json. Records without an encoding hold base64.
+const (
+	bodyEncodingText   = "text"
+	bodyEncodingJson   = "json"
+	bodyEncodingBase64 = "base64"
+)
+
+// Marshal a request with its body readable when it is text
+func (request Request) MarshalJSON() ([]byte, error) {
+	type plainRequest Request
+	body, encoding, err := encodeBody(request.Body, request.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnescaped(struct {
+		plainRequest
+		Body         json.RawMessage `json:",omitempty"`
+		BodyEncoding string          `json:",omitempty"`
+	}{plainRequest(request), body, encoding})
+}
+
+// Unmarshal a request, decoding its body by its encoding
+func (request *Request) UnmarshalJSON(data []byte) error {
+	type plainRequest Request
+	stored := struct {
+		*plainRequest
+		Body         json.RawMessage
+		BodyEncoding string
+	}{plainRequest: (*plainRequest)(request)}
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	request.Body, err = decodeBody(stored.Body, stored.BodyEncoding)
+	return err
+}
+
+// Marshal a response with its body readable when it is text
+func (response Response) MarshalJSON() ([]byte, error) {
+	type plainResponse Response
+	body, encoding, err := encodeBody(response.Body, response.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	return marshalUnescaped(struct {
+		plainResponse
+		Body         json.RawMessage `json:",omitempty"`
+		BodyEncoding string          `json:",omitempty"`
+	}{plainResponse(response), body, encoding})
+}
+
+// Unmarshal a response, decoding its body by its encoding
+func (response *Response) UnmarshalJSON(data []byte) error {
+	type plainResponse Response
+	stored := struct {
+		*plainResponse
+		Body         json.RawMessage
+		BodyEncoding string
+	}{plainResponse: (*plainResponse)(response)}
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	response.Body, err = decodeBody(stored.Body, stored.BodyEncoding)
+	return err
+}
+
+//
+//	Private functions
+//
+
+// Encode a body as embedded json or a string for text content types, otherwise as base64
+func encodeBody(body []byte, contentType string) (json.RawMessage, string, error) {
+	if len(body) == 0 {
+		return nil, "", nil
+	}
+
+	encoding := bodyEncodingBase64
+	var encoded []byte
+	var err error
+	if isTextContentType(contentType) && utf8.Valid(body) {
+		if isJsonContentType(contentType) && isEmbeddableJson(body) {
+			return json.RawMessage(body), bodyEncodingJson, nil
+		}
+		encoding = bodyEncodingText
+		encoded, err = marshalUnescaped(string(body))
+	} else {
+		encoded, err = json.Marshal(body)
+	}
+	if err != nil {
+		return nil, "", errors.New("Error encoding body json: " + err.Error())
+	}
+	return encoded, encoding, nil
+}
+
+// Marshal json leaving HTML characters unescaped, so bodies stay readable
+// unless the enclosing json escapes them
+func marshalUnescaped(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+func decodeBody(encoded json.RawMessage, encoding string) ([]byte, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	// An embedded body may be the JSON value null, which the encoding tells apart from no body
+	if encoding == bodyEncodingJson {
+		return append([]byte{}, encoded...), nil
+	} else if string(encoded) == "null" {
+		return nil, nil
+	} else if encoding == bodyEncodingText {
+		var text string
+		err := json.Unmarshal(encoded, &text)
+		if err != nil {
+			return nil, errors.New("Error decoding text body: " + err.Error())
+		}
+		return []byte(text), nil
+	} else if encoding == bodyEncodingBase64 || encoding == "" {
+		var body []byte
+		err := json.Unmarshal(encoded, &body)
+		if err != nil {
+			return nil, errors.New("Error decoding base64 body: " + err.Error())
+		}
+		return body, nil
+	}
+	return nil, errors.New("Unknown body encoding '" + encoding + "'.")
+}
+
+// Determine if a content type is JSON, XML, HTML, form data or other text
+func isTextContentType(contentType string) bool {
+	mediaType := parseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		isJsonContentType(contentType) ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/javascript" ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+func isJsonContentType(contentType string) bool {
+	mediaType := parseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Media type of a content type without parameters, in lower case
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
+
+// Determine if JSON survives being embedded unchanged. Marshalling compacts
+// embedded JSON and escapes HTML characters, so other JSON is saved as text.
+func isEmbeddableJson(body []byte) bool {
+	var compacted bytes.Buffer
+	err := json.Compact(&compacted, body)
+	if err != nil {
+		return false
+	}
+
+	var escaped bytes.Buffer
+	json.HTMLEscape(&escaped, compacted.Bytes())
+	return bytes.Equal(escaped.Bytes(), body)
+}
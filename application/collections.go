@@ -0,0 +1,426 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request saved under a name for reuse. Requests saved from the command line
+// keep their arguments, so they are parsed again when run. Values of sensitive
+// headers are redacted, and kept in the credential store by header name.
+type SavedRequest struct {
+	Name              string
+	Args              []string
+	Request           Request
+	HeaderCredentials map[string][]string
+}
+
+// Options for running saved requests
+func newRunOptionSet() *OptionSet {
+	opts := NewOptionSet("Run", "run [NAME | COLLECTION] FLAGS")
+	addReplayOverrideOptions(opts)
+	opts.Bool("", "check-status", "Exit with an error code on 4xx and 5xx responses")
+	opts.String("", "env", "NAME", "", "Fill {{variable}} templates from a named environment")
+	opts.List("", "extract", "NAME=$.path|header:NAME|regex:RE", "Save a response value to the session or environment")
+	return opts
+}
+
+// Save a request given by HTTP arguments under a name
+func (app *Application) RunSave() error {
+	if len(app.Args) < 3 {
+		return errors.New("Missing request name or URL. Usage: gohttp save NAME [REQUESTMETHOD] URL FLAGS")
+	}
+	name := app.Args[1]
+	err := validateRequestName(name)
+	if err != nil {
+		return err
+	}
+
+	app.Args = app.Args[2:]
+	err = app.CreateRequest()
+	if err != nil {
+		return err
+	}
+
+	err = app.saveNamedRequest(name, app.Args, app.Request)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Saved request as: " + name)
+	return nil
+}
+
+// Run a saved request, or every request in a collection
+func (app *Application) RunSaved() error {
+	opts := newRunOptionSet()
+	err := opts.Parse(app.Args[1:])
+	if err != nil {
+		return err
+	}
+	// Keep secrets out of the arguments saved to history
+	app.Args = append([]string{app.Args[0]}, app.redactor.redactArgs(opts, app.Args[1:])...)
+
+	args := opts.Args()
+	if len(args) < 1 {
+		return app.printSavedRequests()
+	} else if len(args) > 1 {
+		return errors.New("Unexpected argument '" + args[1] + "'. Usage: gohttp run [NAME | COLLECTION] FLAGS")
+	}
+	name := strings.Trim(args[0], "/")
+	err = validateRequestName(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path.Join(app.CollectionsPath, name+".json")); err == nil {
+		err = app.runSavedRequest(name, opts)
+		if err != nil {
+			return err
+		}
+		return app.checkResponseStatus()
+	}
+
+	dirInfo, err := os.Stat(path.Join(app.CollectionsPath, name))
+	if err != nil || !dirInfo.IsDir() {
+		return errors.New("No saved request or collection named '" + name + "'.")
+	}
+	return app.runCollection(name, opts)
+}
+
+//
+//	Private functions
+//
+
+// Matches one part of a request name; collections separate parts with /
+var requestNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// Save a request to the collections directory under a name, in a collection
+// when the name has a folder such as users/create
+func (app *Application) saveNamedRequest(name string, args []string, request Request) error {
+	err := validateRequestName(name)
+	if err != nil {
+		return err
+	}
+
+	err = app.storeCredential(request.Auth)
+	if err != nil {
+		return err
+	}
+	headerCredentials, err := app.storeHeaderCredentials(request.Header)
+	if err != nil {
+		return err
+	}
+	request.Header = app.redactor.redactHeader(request.Header)
+	if request.Template != nil {
+		template := *request.Template
+		template.Header = app.redactor.redactHeader(template.Header)
+		request.Template = &template
+	}
+
+	dirPath := path.Join(app.CollectionsPath, path.Dir(name))
+	err = os.MkdirAll(dirPath, 0700)
+	if err != nil {
+		return errors.New("Failed to create directory " + dirPath + "\n" + err.Error())
+	}
+
+	saved := SavedRequest{Name: name, Args: args, Request: request, HeaderCredentials: headerCredentials}
+	jsonBytes, err := json.Marshal(saved)
+	if err != nil {
+		return errors.New("Error creating saved request json: " + err.Error())
+	}
+	fileName := path.Base(name) + ".json"
+	err = ioutil.WriteFile(path.Join(dirPath, fileName), jsonBytes, 0600)
+	if err != nil {
+		return errors.New("Error writing saved request file " + fileName + ": " + err.Error())
+	}
+	return nil
+}
+
+// Keep the values of sensitive headers in the credential store, returning their
+// credential ids by header name. Values already redacted in history are skipped.
+func (app *Application) storeHeaderCredentials(header http.Header) (map[string][]string, error) {
+	var headerCredentials map[string][]string
+	for key, values := range header {
+		if !app.redactor.headers[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		for i, j := 0, len(values); i < j; i++ {
+			if values[i] == redactedValue {
+				continue
+			}
+			auth := &Auth{Type: "header", Username: http.CanonicalHeaderKey(key), Token: values[i]}
+			err := app.storeCredential(auth)
+			if err != nil {
+				return nil, err
+			}
+			if headerCredentials == nil {
+				headerCredentials = make(map[string][]string)
+			}
+			headerCredentials[auth.Username] = append(headerCredentials[auth.Username], auth.CredentialId)
+		}
+	}
+	return headerCredentials, nil
+}
+
+// Header values kept in the credential store for a saved request, by header name
+func (app *Application) loadHeaderCredentials(saved SavedRequest) (http.Header, error) {
+	header := make(http.Header)
+	for key, credentialIds := range saved.HeaderCredentials {
+		for i, j := 0, len(credentialIds); i < j; i++ {
+			auth := &Auth{CredentialId: credentialIds[i]}
+			err := app.loadCredential(auth)
+			if err != nil {
+				return nil, err
+			}
+			header.Add(key, auth.Token)
+		}
+	}
+	return header, nil
+}
+
+func (app *Application) loadSavedRequest(name string) (SavedRequest, error) {
+	saved := SavedRequest{}
+	jsonBytes, err := ioutil.ReadFile(path.Join(app.CollectionsPath, name+".json"))
+	if os.IsNotExist(err) {
+		return saved, errors.New("No saved request named '" + name + "'.")
+	} else if err != nil {
+		return saved, errors.New("Error reading saved request " + name + ": " + err.Error())
+	}
+
+	err = json.Unmarshal(jsonBytes, &saved)
+	if err != nil {
+		return saved, errors.New("Error unmarshalling saved request " + name + ": " + err.Error())
+	}
+	return saved, nil
+}
+
+// Build the request for a saved request, through CreateRequest when it has arguments, then send and save it
+func (app *Application) runSavedRequest(name string, opts *OptionSet) error {
+	saved, err := app.loadSavedRequest(name)
+	if err != nil {
+		return err
+	}
+
+	if len(saved.Args) > 0 {
+		app.Args, err = app.restoreSavedArgs(saved)
+		if err != nil {
+			return err
+		}
+		// The last --env given wins, so this replaces an environment saved with the request
+		if opts.Value("env") != "" {
+			app.Args = append(app.Args, "--env", opts.Value("env"))
+		}
+		err = app.CreateRequest()
+		if err != nil {
+			return err
+		}
+	} else if opts.Value("env") != "" {
+		return errors.New("--env only applies to requests saved with their arguments, by save or history name.")
+	} else {
+		app.Request = saved.Request
+		header, err := app.loadHeaderCredentials(saved)
+		if err != nil {
+			return err
+		}
+		if app.Request.Header == nil {
+			app.Request.Header = make(http.Header)
+		}
+		for key, values := range header {
+			app.Request.Header[key] = values
+		}
+	}
+
+	err = app.overrideReplayRequest(opts)
+	if err != nil {
+		return err
+	}
+	warnRedactedRequest(&app.Request)
+	err = app.loadCredential(app.Request.Auth)
+	if err != nil {
+		return err
+	}
+	if opts.Flag("check-status") {
+		app.Request.CheckStatus = true
+	}
+	app.Request.Extract = append(append([]string{}, app.Request.Extract...), opts.Values("extract")...)
+	err = validateExtract(&app.Request)
+	if err != nil {
+		return err
+	}
+
+	err = app.SendRequest()
+	if err != nil {
+		return err
+	}
+	return app.SaveApp()
+}
+
+// Copy of a saved request's arguments with redacted --auth, --bearer and header
+// values replaced by the stored credentials, so they parse again
+func (app *Application) restoreSavedArgs(saved SavedRequest) ([]string, error) {
+	opts := newHttpOptionSet()
+	err := opts.Parse(saved.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := app.loadHeaderCredentials(saved)
+	if err != nil {
+		return nil, err
+	}
+	restoreHeader := func(value string) string {
+		parts := strings.SplitN(value, ":", 2)
+		key := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) != redactedValue || len(header[key]) == 0 {
+			return value
+		}
+		restored := parts[0] + ": " + header[key][0]
+		header[key] = header[key][1:]
+		return restored
+	}
+	args := opts.MapArgs(saved.Args, "header", restoreHeader)
+	args = opts.MapArgs(args, "session-header", restoreHeader)
+	if opts.Value("auth") != redactedValue && opts.Value("bearer") != redactedValue {
+		return args, nil
+	}
+
+	if saved.Request.Auth == nil || saved.Request.Auth.CredentialId == "" {
+		return nil, errors.New("The credentials of saved request '" + saved.Name + "' are not stored. Save it again with --auth or --bearer.")
+	}
+	auth := *saved.Request.Auth
+	err = app.loadCredential(&auth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Type == "bearer" {
+		return opts.RedactArgs(args, auth.Token, "bearer"), nil
+	}
+	return opts.RedactArgs(args, auth.Username+":"+auth.Password, "auth"), nil
+}
+
+// Run every request in a collection and its sub-collections in name order
+func (app *Application) runCollection(collection string, opts *OptionSet) error {
+	if opts.Value("output") != "" {
+		return errors.New("--output can only be used when running one request.")
+	}
+
+	names, err := app.listSavedRequests(collection)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("Collection '" + collection + "' has no saved requests.")
+	}
+
+	numFailed := 0
+	for i, j := 0, len(names); i < j; i++ {
+		fmt.Println("Running " + names[i] + "...")
+		runApp := app.newRunApp()
+		err = runApp.runSavedRequest(names[i], opts)
+		if err == nil {
+			err = runApp.checkResponseStatus()
+		}
+		if err != nil {
+			numFailed++
+			fmt.Println(names[i] + " failed: " + err.Error())
+		} else {
+			fmt.Println(names[i] + ": " + runApp.Response.Status + " (" + roundDuration(runApp.Duration).String() + ")")
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Ran", len(names), "requests:", len(names)-numFailed, "succeeded,", numFailed, "failed.")
+	if numFailed > 0 {
+		return errors.New(strconv.Itoa(numFailed) + " of " + strconv.Itoa(len(names)) + " requests failed.")
+	}
+	return nil
+}
+
+// Names of the saved requests in a collection and its sub-collections, or in all collections
+func (app *Application) listSavedRequests(collection string) ([]string, error) {
+	names := make([]string, 0)
+	dirPath := path.Join(app.CollectionsPath, collection)
+	fileInfos, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return names, errors.New("Error reading collection " + collection + ": " + err.Error())
+	}
+
+	for i, j := 0, len(fileInfos); i < j; i++ {
+		name := fileInfos[i].Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if collection != "" {
+			name = collection + "/" + name
+		}
+
+		if fileInfos[i].IsDir() {
+			subNames, err := app.listSavedRequests(name)
+			if err != nil {
+				return names, err
+			}
+			names = append(names, subNames...)
+		} else if strings.HasSuffix(name, ".json") {
+			names = append(names, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Print every saved request with its method and URL
+func (app *Application) printSavedRequests() error {
+	names, err := app.listSavedRequests("")
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println("No saved requests. Save one with: gohttp save NAME [REQUESTMETHOD] URL FLAGS")
+		return nil
+	}
+
+	for i, j := 0, len(names); i < j; i++ {
+		saved, err := app.loadSavedRequest(names[i])
+		if err != nil {
+			return err
+		}
+		fmt.Println(names[i] + "\t" + saved.Request.Method + " " + saved.Request.URL.String())
+	}
+	return nil
+}
+
+// Copy of the application for sending another request, without the previous request's state
+func (app *Application) newRunApp() *Application {
+	runApp := *app
+	runApp.Id = ""
+	runApp.ReplayOf = ""
+	runApp.StartTime = time.Now()
+	runApp.Request = Request{}
+	runApp.Response = Response{}
+	runApp.OutputFilePath = ""
+	runApp.InputFilePath = ""
+	runApp.session = nil
+	runApp.cookieJar = nil
+	return &runApp
+}
+
+// Check a request name is one or more parts of letters, numbers, '.', '_' and '-', separated by /
+func validateRequestName(name string) error {
+	parts := strings.Split(name, "/")
+	for i, j := 0, len(parts); i < j; i++ {
+		if !requestNameRegexp.MatchString(parts[i]) || strings.HasPrefix(parts[i], ".") {
+			return errors.New("Invalid request name '" + name + "'. Use letters, numbers, '.', '_' and '-', with / between collections.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// User configuration, read from ~/.gohttp/config.json
+type Config struct {
+	Retention  RetentionPolicy
+	Redaction  RedactionRules
+	Encryption EncryptionConfig
+}
+
+// Limits on the history kept, enforced after each request is saved
+type RetentionPolicy struct {
+	MaxAge     string
+	MaxRecords int
+	MaxSize    string
+}
+
+//
+//	Private functions
+//
+
+// Load the user configuration, which is optional
+func (app *Application) loadConfig() error {
+	app.config = Config{}
+
+	configBytes, err := ioutil.ReadFile(app.ConfigPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Error reading config file " + app.ConfigPath + ": " + err.Error())
+	}
+
+	err = json.Unmarshal(configBytes, &app.config)
+	if err != nil {
+		return errors.New("Error unmarshalling config file " + app.ConfigPath + ": " + err.Error())
+	}
+
+	_, err = parseRetentionPolicy(app.config.Retention)
+	if err != nil {
+		return errors.New("Invalid retention policy in config file " + app.ConfigPath + ": " + err.Error())
+	}
+
+	_, err = newHistoryRedactor(app.config.Redaction)
+	if err != nil {
+		return errors.New("Invalid redaction rules in config file " + app.ConfigPath + ": " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Lines of unchanged text shown around each change
+const diffContextLines = 3
+
+// Larger bodies are diffed as a whole instead of line by line, to bound memory
+const diffMaxCells = 4000000
+
+// Matches JSON keys that can be written as .key in a path
+var jsonPathKeyRegexp = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$-]*$`)
+
+// Line of a text diff: ' ' unchanged, '-' only in the first text, '+' only in the second
+type diffLine struct {
+	Op    byte
+	Text  string
+	ALine int
+	BLine int
+}
+
+// Compare two history records' status, headers, timing and bodies
+func (app *Application) RunHistoryDiff(opts *OptionSet) error {
+	args := opts.Args()
+	if len(args) != 2 {
+		return errors.New("Expected two history record indexes or IDs to compare.")
+	}
+
+	ignorePaths := make([][]string, 0)
+	ignoreValues := opts.Values("ignore")
+	for i, j := 0, len(ignoreValues); i < j; i++ {
+		ignorePath, err := parseJsonPath(ignoreValues[i])
+		if err != nil {
+			return err
+		}
+		ignorePaths = append(ignorePaths, ignorePath)
+	}
+	ignoreHeaders := make(map[string]bool)
+	ignoreHeaderValues := opts.Values("ignore-header")
+	for i, j := 0, len(ignoreHeaderValues); i < j; i++ {
+		ignoreHeaders[http.CanonicalHeaderKey(ignoreHeaderValues[i])] = true
+	}
+
+	historyA, err := app.loadAppFromHistory(args[:1])
+	if err != nil {
+		return err
+	}
+	historyB, err := app.loadAppFromHistory(args[1:])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("--- " + describeHistoryApp(&historyA))
+	fmt.Println("+++ " + describeHistoryApp(&historyB))
+
+	requestA := historyA.Request.Method + " " + historyA.Request.URL.String()
+	requestB := historyB.Request.Method + " " + historyB.Request.URL.String()
+	if requestA != requestB {
+		fmt.Println("Request:", requestA, "->", requestB)
+	}
+	if historyA.Response.Status == historyB.Response.Status {
+		fmt.Println("Status:", historyA.Response.Status)
+	} else {
+		fmt.Println("Status:", historyA.Response.Status, "->", historyB.Response.Status)
+	}
+	printDurationChange("Duration:", historyA.Duration, historyB.Duration)
+	printDurationChange("Wait:", historyA.Response.Timings.Wait, historyB.Response.Timings.Wait)
+
+	printHeaderDiff("Response Headers", historyA.Response.Header, historyB.Response.Header, ignoreHeaders)
+	if !bytes.Equal(historyA.Request.Body, historyB.Request.Body) {
+		printBodyDiff("Request Body", historyA.Request.Body, historyB.Request.Body,
+			historyA.Request.ContentType, historyB.Request.ContentType, ignorePaths)
+	}
+	printBodyDiff("Response Body", historyA.Response.Body, historyB.Response.Body,
+		historyA.Response.ContentType, historyB.Response.ContentType, ignorePaths)
+	return nil
+}
+
+//
+//	Private functions
+//
+
+// One line summary of a history record
+func describeHistoryApp(historyApp *Application) string {
+	return historyApp.Id + " " + historyApp.StartTime.Format("2006-01-02 15:04:05") + " " +
+		historyApp.Request.Method + " " + historyApp.Request.URL.String()
+}
+
+func printDurationChange(label string, a time.Duration, b time.Duration) {
+	change := roundDuration(b - a).String()
+	if b >= a {
+		change = "+" + change
+	}
+	fmt.Println(label, roundDuration(a), "->", roundDuration(b), "("+change+")")
+}
+
+// Print headers added, removed or changed between two records
+func printHeaderDiff(title string, a http.Header, b http.Header, ignore map[string]bool) {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, present := a[key]; !present {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0)
+	for i, j := 0, len(keys); i < j; i++ {
+		if ignore[http.CanonicalHeaderKey(keys[i])] {
+			continue
+		}
+		valueA, presentA := a[keys[i]]
+		valueB, presentB := b[keys[i]]
+		if presentA && presentB && reflect.DeepEqual(valueA, valueB) {
+			continue
+		}
+		if presentA {
+			lines = append(lines, "- "+keys[i]+": "+strings.Join(valueA, ", "))
+		}
+		if presentB {
+			lines = append(lines, "+ "+keys[i]+": "+strings.Join(valueB, ", "))
+		}
+	}
+	printDiffSection(title, lines)
+}
+
+// Print a structural diff of JSON bodies, or a unified diff of other text
+func printBodyDiff(title string, a []byte, b []byte, contentTypeA string, contentTypeB string, ignore [][]string) {
+	if bytes.Equal(a, b) {
+		fmt.Println(title + ": identical")
+		return
+	}
+
+	if isJsonContentType(contentTypeA) && isJsonContentType(contentTypeB) {
+		valueA, errA := decodeJsonValue(a)
+		valueB, errB := decodeJsonValue(b)
+		if errA == nil && errB == nil {
+			lines := make([]string, 0)
+			diffJsonValues(valueA, valueB, "$", []string{}, ignore, &lines)
+			printDiffSection(title+" (JSON)", lines)
+			return
+		}
+	}
+
+	if !utf8.Valid(a) || !utf8.Valid(b) {
+		fmt.Println(title + ": binary bodies differ (" + formatSize(len(a)) + " -> " + formatSize(len(b)) + ")")
+		return
+	}
+
+	linesA := splitDiffLines(string(formatBody(a, contentTypeA)))
+	linesB := splitDiffLines(string(formatBody(b, contentTypeB)))
+	printDiffSection(title, unifiedDiff(diffTextLines(linesA, linesB), diffContextLines))
+}
+
+func printDiffSection(title string, lines []string) {
+	if len(lines) == 0 {
+		fmt.Println(title + ": no differences")
+		return
+	}
+
+	fmt.Println(title + ":")
+	for i, j := 0, len(lines); i < j; i++ {
+		fmt.Println(lines[i])
+	}
+}
+
+func decodeJsonValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("Unexpected data after JSON value.")
+	}
+	return value, nil
+}
+
+// Append lines for values added (+), removed (-) or changed (- then +) between two JSON values.
+// Object key order does not matter, and values at ignored paths are skipped.
+func diffJsonValues(a interface{}, b interface{}, path string, segments []string, ignore [][]string, lines *[]string) {
+	if isIgnoredJsonPath(segments, ignore) {
+		return
+	}
+
+	objectA, isObjectA := a.(map[string]interface{})
+	objectB, isObjectB := b.(map[string]interface{})
+	arrayA, isArrayA := a.([]interface{})
+	arrayB, isArrayB := b.([]interface{})
+	if isObjectA && isObjectB {
+		keys := make([]string, 0, len(objectA)+len(objectB))
+		for key := range objectA {
+			keys = append(keys, key)
+		}
+		for key := range objectB {
+			if _, present := objectA[key]; !present {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for i, j := 0, len(keys); i < j; i++ {
+			childPath := path + "." + keys[i]
+			if !jsonPathKeyRegexp.MatchString(keys[i]) {
+				childPath = path + "[" + strconv.Quote(keys[i]) + "]"
+			}
+			childSegments := append(append([]string{}, segments...), keys[i])
+
+			valueA, presentA := objectA[keys[i]]
+			valueB, presentB := objectB[keys[i]]
+			if presentA && presentB {
+				diffJsonValues(valueA, valueB, childPath, childSegments, ignore, lines)
+			} else if !isIgnoredJsonPath(childSegments, ignore) {
+				if presentA {
+					*lines = append(*lines, "- "+childPath+": "+formatJsonValue(valueA))
+				} else {
+					*lines = append(*lines, "+ "+childPath+": "+formatJsonValue(valueB))
+				}
+			}
+		}
+	} else if isArrayA && isArrayB {
+		numItems := len(arrayA)
+		if len(arrayB) > numItems {
+			numItems = len(arrayB)
+		}
+
+		for i := 0; i < numItems; i++ {
+			childPath := path + "[" + strconv.Itoa(i) + "]"
+			childSegments := append(append([]string{}, segments...), "["+strconv.Itoa(i)+"]")
+			if i < len(arrayA) && i < len(arrayB) {
+				diffJsonValues(arrayA[i], arrayB[i], childPath, childSegments, ignore, lines)
+			} else if !isIgnoredJsonPath(childSegments, ignore) {
+				if i < len(arrayA) {
+					*lines = append(*lines, "- "+childPath+": "+formatJsonValue(arrayA[i]))
+				} else {
+					*lines = append(*lines, "+ "+childPath+": "+formatJsonValue(arrayB[i]))
+				}
+			}
+		}
+	} else if !reflect.DeepEqual(a, b) {
+		*lines = append(*lines, "- "+path+": "+formatJsonValue(a))
+		*lines = append(*lines, "+ "+path+": "+formatJsonValue(b))
+	}
+}
+
+func formatJsonValue(value interface{}) string {
+	valueBytes, err := marshalUnescaped(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(valueBytes)
+}
+
+// Parse a path such as $.data.items[*].id or ..updatedAt into segments.
+// Keys are segments as is, array indexes are [N], * matches any one segment
+// and ** any number of segments.
+func parseJsonPath(pattern string) ([]string, error) {
+	invalid := errors.New("Invalid JSON path '" + pattern + "'. Expected a path such as $.data.items[*].id or ..updatedAt.")
+	path := strings.TrimPrefix(strings.TrimSpace(pattern), "$")
+	if path == "" {
+		return nil, invalid
+	}
+	if path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+
+	segments := make([]string, 0)
+	for len(path) > 0 {
+		if strings.HasPrefix(path, "..") {
+			segments = append(segments, "**")
+			path = path[2:]
+			if path == "" {
+				return nil, invalid
+			} else if path[0] == '[' {
+				continue
+			}
+			path = "." + path
+		}
+
+		if path[0] == '.' {
+			end := strings.IndexAny(path[1:], ".[")
+			if end < 0 {
+				end = len(path) - 1
+			}
+			key := path[1 : end+1]
+			if key == "" {
+				return nil, invalid
+			}
+			segments = append(segments, key)
+			path = path[end+1:]
+		} else if path[0] == '[' {
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, invalid
+			}
+			inner := path[1:end]
+			if inner == "*" {
+				segments = append(segments, "*")
+			} else if _, err := strconv.Atoi(inner); err == nil {
+				segments = append(segments, "["+inner+"]")
+			} else if key, err := strconv.Unquote(inner); err == nil {
+				segments = append(segments, key)
+			} else {
+				return nil, invalid
+			}
+			path = path[end+1:]
+		} else {
+			return nil, invalid
+		}
+	}
+	return segments, nil
+}
+
+func isIgnoredJsonPath(segments []string, ignore [][]string) bool {
+	for i, j := 0, len(ignore); i < j; i++ {
+		if matchJsonPath(ignore[i], segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchJsonPath(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchJsonPath(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 || pattern[0] != "*" && pattern[0] != segments[0] {
+		return false
+	}
+	return matchJsonPath(pattern[1:], segments[1:])
+}
+
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Diff lines by their longest common subsequence, after trimming common leading and trailing lines
+func diffTextLines(a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	middleA := a[prefix : len(a)-suffix]
+	middleB := b[prefix : len(b)-suffix]
+
+	diffLines := make([]diffLine, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		diffLines = append(diffLines, diffLine{Op: ' ', Text: a[i], ALine: i, BLine: i})
+	}
+
+	n, m := len(middleA), len(middleB)
+	if n*m > diffMaxCells {
+		for i := 0; i < n; i++ {
+			diffLines = append(diffLines, diffLine{Op: '-', Text: middleA[i], ALine: prefix + i, BLine: prefix})
+		}
+		for i := 0; i < m; i++ {
+			diffLines = append(diffLines, diffLine{Op: '+', Text: middleB[i], ALine: prefix + n, BLine: prefix + i})
+		}
+	} else {
+		// lengths[i][k] is the longest common subsequence of middleA[i:] and middleB[k:]
+		lengths := make([][]int32, n+1)
+		for i := 0; i <= n; i++ {
+			lengths[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for k := m - 1; k >= 0; k-- {
+				if middleA[i] == middleB[k] {
+					lengths[i][k] = lengths[i+1][k+1] + 1
+				} else if lengths[i+1][k] >= lengths[i][k+1] {
+					lengths[i][k] = lengths[i+1][k]
+				} else {
+					lengths[i][k] = lengths[i][k+1]
+				}
+			}
+		}
+
+		i, k := 0, 0
+		for i < n || k < m {
+			if i < n && k < m && middleA[i] == middleB[k] {
+				diffLines = append(diffLines, diffLine{Op: ' ', Text: middleA[i], ALine: prefix + i, BLine: prefix + k})
+				i++
+				k++
+			} else if k == m || i < n && lengths[i+1][k] >= lengths[i][k+1] {
+				diffLines = append(diffLines, diffLine{Op: '-', Text: middleA[i], ALine: prefix + i, BLine: prefix + k})
+				i++
+			} else {
+				diffLines = append(diffLines, diffLine{Op: '+', Text: middleB[k], ALine: prefix + i, BLine: prefix + k})
+				k++
+			}
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		diffLines = append(diffLines, diffLine{Op: ' ', Text: a[len(a)-suffix+i], ALine: len(a) - suffix + i, BLine: len(b) - suffix + i})
+	}
+	return diffLines
+}
+
+// Format diff lines as unified diff hunks with context lines around each change
+func unifiedDiff(diffLines []diffLine, context int) []string {
+	lines := make([]string, 0)
+	for start := 0; start < len(diffLines); {
+		if diffLines[start].Op == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk while the next change is within twice the context
+		end := start
+		for k := start; k < len(diffLines) && k <= end+2*context; k++ {
+			if diffLines[k].Op != ' ' {
+				end = k
+			}
+		}
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + context + 1
+		if hunkEnd > len(diffLines) {
+			hunkEnd = len(diffLines)
+		}
+
+		countA, countB := 0, 0
+		hunkLines := make([]string, 0, hunkEnd-hunkStart)
+		for k := hunkStart; k < hunkEnd; k++ {
+			if diffLines[k].Op != '+' {
+				countA++
+			}
+			if diffLines[k].Op != '-' {
+				countB++
+			}
+			hunkLines = append(hunkLines, string(diffLines[k].Op)+diffLines[k].Text)
+		}
+		lines = append(lines, "@@ -"+hunkRange(diffLines[hunkStart].ALine, countA)+" +"+hunkRange(diffLines[hunkStart].BLine, countB)+" @@")
+		lines = append(lines, hunkLines...)
+		start = hunkEnd
+	}
+	return lines
+}
+
+// Unified diff range of a hunk, with lines numbered from 1
+func hunkRange(firstLine int, count int) string {
+	if count == 0 {
+		return strconv.Itoa(firstLine) + ",0"
+	}
+	return strconv.Itoa(firstLine+1) + "," + strconv.Itoa(count)
+}
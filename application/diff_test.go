@@ -0,0 +1,118 @@
+package application
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJsonPath(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+		err      bool
+	}{
+		{pattern: "$.data.id", expected: []string{"data", "id"}},
+		{pattern: "data.id", expected: []string{"data", "id"}},
+		{pattern: "$.items[0].id", expected: []string{"items", "[0]", "id"}},
+		{pattern: "$.items[*].id", expected: []string{"items", "*", "id"}},
+		{pattern: "$.a.*", expected: []string{"a", "*"}},
+		{pattern: "..updatedAt", expected: []string{"**", "updatedAt"}},
+		{pattern: "$.a..id", expected: []string{"a", "**", "id"}},
+		{pattern: "$..[1]", expected: []string{"**", "[1]"}},
+		{pattern: `$["content-type"].v`, expected: []string{"content-type", "v"}},
+		{pattern: " $[2] ", expected: []string{"[2]"}},
+		{pattern: "", err: true},
+		{pattern: "$", err: true},
+		{pattern: "$.a..", err: true},
+		{pattern: "$.a.", err: true},
+		{pattern: "$.items[0", err: true},
+		{pattern: "$.items[x]", err: true},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		segments, err := parseJsonPath(tests[i].pattern)
+		if tests[i].err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", tests[i].pattern, segments)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tests[i].pattern, err)
+		} else if !reflect.DeepEqual(segments, tests[i].expected) {
+			t.Errorf("%q: got %q, expected %q", tests[i].pattern, segments, tests[i].expected)
+		}
+	}
+}
+
+func TestDiffJsonValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		ignore   []string
+		expected []string
+	}{
+		{name: "equal, key order ignored", a: `{"a": 1, "b": [1, 2]}`, b: `{"b": [1, 2], "a": 1}`, expected: []string{}},
+		{name: "changed", a: `{"a": 1}`, b: `{"a": 2}`, expected: []string{"- $.a: 1", "+ $.a: 2"}},
+		{name: "number precision", a: `{"n": 1.0}`, b: `{"n": 1}`, expected: []string{"- $.n: 1.0", "+ $.n: 1"}},
+		{name: "added and removed", a: `{"a": 1, "b": "x"}`, b: `{"a": 1, "c": null}`, expected: []string{`- $.b: "x"`, "+ $.c: null"}},
+		{name: "nested", a: `{"d": {"items": [{"id": 1}]}}`, b: `{"d": {"items": [{"id": 2}]}}`,
+			expected: []string{"- $.d.items[0].id: 1", "+ $.d.items[0].id: 2"}},
+		{name: "array length", a: `[1, 2, 3]`, b: `[1]`, expected: []string{"- $[1]: 2", "- $[2]: 3"}},
+		{name: "type change", a: `{"a": [1]}`, b: `{"a": {"0": 1}}`, expected: []string{"- $.a: [1]", `+ $.a: {"0":1}`}},
+		{name: "quoted key", a: `{"a.b": "x"}`, b: `{"a.b": "y"}`,
+			expected: []string{`- $["a.b"]: "x"`, `+ $["a.b"]: "y"`}},
+		{name: "ignored key", a: `{"id": 1, "at": 1}`, b: `{"id": 1, "at": 2}`, ignore: []string{"$.at"}, expected: []string{}},
+		{name: "ignored added key", a: `{}`, b: `{"at": 2}`, ignore: []string{"at"}, expected: []string{}},
+		{name: "ignored wildcard", a: `{"items": [{"at": 1, "v": 1}]}`, b: `{"items": [{"at": 2, "v": 2}]}`, ignore: []string{"$.items[*].at"},
+			expected: []string{"- $.items[0].v: 1", "+ $.items[0].v: 2"}},
+		{name: "ignored anywhere", a: `{"at": 1, "d": {"x": {"at": 1}}}`, b: `{"at": 2, "d": {"x": {"at": 2}}}`, ignore: []string{"..at"}, expected: []string{}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		test := tests[i]
+		a, err := decodeJsonValue([]byte(test.a))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		b, err := decodeJsonValue([]byte(test.b))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		ignore := make([][]string, 0, len(test.ignore))
+		for k, l := 0, len(test.ignore); k < l; k++ {
+			segments, err := parseJsonPath(test.ignore[k])
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			ignore = append(ignore, segments)
+		}
+
+		lines := make([]string, 0)
+		diffJsonValues(a, b, "$", []string{}, ignore, &lines)
+		if strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: got %q, expected %q", test.name, lines, test.expected)
+		}
+	}
+}
+
+func TestDecodeJsonValue(t *testing.T) {
+	tests := []struct {
+		data string
+		err  bool
+	}{
+		{data: `{"a": 1}`},
+		{data: ` [1, 2] `},
+		{data: `{"a": 1} {"b": 2}`, err: true},
+		{data: `{"a":`, err: true},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		_, err := decodeJsonValue([]byte(tests[i].data))
+		if (err != nil) != tests[i].err {
+			t.Errorf("%q: error = %v, expected error %v", tests[i].data, err, tests[i].err)
+		}
+	}
+}
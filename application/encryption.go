@@ -0,0 +1,303 @@
+package application
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Environment variable holding the history passphrase, unless configured otherwise
+const defaultPassphraseEnv = "GOHTTP_HISTORY_PASSPHRASE"
+
+// PBKDF2-SHA256 iterations for new history keys
+const encryptionIterations = 600000
+
+// Plaintext sealed into the encryption metadata to verify a key
+const encryptionCheck = "gohttp history"
+
+// Encryption settings in the user configuration
+type EncryptionConfig struct {
+	Enabled       bool
+	PassphraseEnv string
+	KeyFile       string
+}
+
+// Key derivation parameters saved alongside encrypted history
+type encryptionMetadata struct {
+	Salt       []byte
+	Iterations int
+	Check      string
+}
+
+// Encrypts and decrypts history lines with AES-256-GCM
+type historyCipher struct {
+	aead     cipher.AEAD
+	blobKey  []byte
+	metadata encryptionMetadata
+}
+
+// Rewrite history with a new key, or unencrypted
+func (app *Application) RunHistoryRekey(opts *OptionSet) error {
+	if opts.Flag("decrypt") && (opts.Value("passphrase-env") != "" || opts.Value("key-file") != "") {
+		return errors.New("Use either --decrypt or a new passphrase or key file, not both.")
+	}
+
+	var historyCipher *historyCipher
+	if !opts.Flag("decrypt") {
+		// Default to the configured key source, for a new salt and key from the same secret
+		config := app.config.Encryption
+		if opts.Value("passphrase-env") != "" || opts.Value("key-file") != "" {
+			config = EncryptionConfig{PassphraseEnv: opts.Value("passphrase-env"), KeyFile: opts.Value("key-file")}
+		}
+
+		secret, err := encryptionSecret(config)
+		if err != nil {
+			return err
+		}
+		historyCipher, err = newHistoryCipher(secret)
+		if err != nil {
+			return err
+		}
+	}
+
+	stats, err := app.history.Stats()
+	if err != nil {
+		return err
+	}
+	err = app.history.Rekey(historyCipher)
+	if err != nil {
+		return err
+	}
+
+	if historyCipher == nil {
+		fmt.Println("Decrypted", stats.Records, "history records.")
+		if app.config.Encryption.Enabled {
+			fmt.Println("Disable Encryption in " + app.ConfigPath + " to keep new history unencrypted.")
+		}
+	} else {
+		fmt.Println("Encrypted", stats.Records, "history records with a new key.")
+		if opts.Value("passphrase-env") != "" || opts.Value("key-file") != "" || !app.config.Encryption.Enabled {
+			fmt.Println("Update Encryption in " + app.ConfigPath + " to use the new passphrase or key file.")
+		}
+	}
+	return nil
+}
+
+//
+//	Private functions
+//
+
+// Read the secret a key is derived from: a key file, or a passphrase from the environment
+func encryptionSecret(config EncryptionConfig) ([]byte, error) {
+	if config.KeyFile != "" {
+		keyBytes, err := ioutil.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, errors.New("Error reading history key file: " + err.Error())
+		}
+		keyBytes = bytes.TrimSpace(keyBytes)
+		if len(keyBytes) == 0 {
+			return nil, errors.New("History key file " + config.KeyFile + " is empty.")
+		}
+		return keyBytes, nil
+	}
+
+	envName := config.PassphraseEnv
+	if envName == "" {
+		envName = defaultPassphraseEnv
+	}
+	passphrase := os.Getenv(envName)
+	if passphrase == "" {
+		return nil, errors.New("No history passphrase in $" + envName + ". Set it or configure a key file.")
+	}
+	return []byte(passphrase), nil
+}
+
+// Derive a cipher from a secret, with a new random salt
+func newHistoryCipher(secret []byte) (*historyCipher, error) {
+	metadata := encryptionMetadata{Salt: make([]byte, 16), Iterations: encryptionIterations}
+	_, err := rand.Read(metadata.Salt)
+	if err != nil {
+		return nil, errors.New("Error creating history key salt: " + err.Error())
+	}
+
+	historyCipher, err := deriveHistoryCipher(secret, metadata)
+	if err != nil {
+		return nil, err
+	}
+	check, err := historyCipher.seal([]byte(encryptionCheck))
+	if err != nil {
+		return nil, err
+	}
+	historyCipher.metadata.Check = string(check)
+	return historyCipher, nil
+}
+
+// Derive the cipher for existing encrypted history, verifying the secret
+func openHistoryCipher(secret []byte, metadata encryptionMetadata) (*historyCipher, error) {
+	historyCipher, err := deriveHistoryCipher(secret, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := historyCipher.open([]byte(metadata.Check))
+	if err != nil || string(check) != encryptionCheck {
+		return nil, errors.New("Wrong history passphrase or key file.")
+	}
+	return historyCipher, nil
+}
+
+func deriveHistoryCipher(secret []byte, metadata encryptionMetadata) (*historyCipher, error) {
+	key, err := pbkdf2.Key(sha256.New, string(secret), metadata.Salt, metadata.Iterations, 32)
+	if err != nil {
+		return nil, errors.New("Error deriving history key: " + err.Error())
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("Error creating history cipher: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New("Error creating history cipher: " + err.Error())
+	}
+
+	// A separate key names blobs, so names never reveal the encryption key
+	blobKey, err := hkdf.Key(sha256.New, key, nil, "gohttp history blob names", 32)
+	if err != nil {
+		return nil, errors.New("Error deriving history blob key: " + err.Error())
+	}
+	return &historyCipher{aead: aead, blobKey: blobKey, metadata: metadata}, nil
+}
+
+// Encrypt data into a base64 line with a random nonce
+func (historyCipher *historyCipher) seal(data []byte) ([]byte, error) {
+	sealed, err := historyCipher.sealRaw(data)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(line, sealed)
+	return line, nil
+}
+
+// Decrypt a line created by seal
+func (historyCipher *historyCipher) open(line []byte) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, errors.New("Error decoding encrypted history: " + err.Error())
+	}
+	return historyCipher.openRaw(sealed[:n])
+}
+
+// Encrypt data with a random nonce, which is prepended to the result
+func (historyCipher *historyCipher) sealRaw(data []byte) ([]byte, error) {
+	nonce := make([]byte, historyCipher.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, errors.New("Error creating history nonce: " + err.Error())
+	}
+	return historyCipher.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt data created by sealRaw
+func (historyCipher *historyCipher) openRaw(sealed []byte) ([]byte, error) {
+	nonceSize := historyCipher.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("Error decrypting history: data is too short.")
+	}
+	data, err := historyCipher.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.New("Error decrypting history: " + err.Error())
+	}
+	return data, nil
+}
+
+// Load the cipher for a history directory, if its history is encrypted or encryption is enabled
+func loadHistoryCipher(dirPath string, config EncryptionConfig) (*historyCipher, error) {
+	metadataPath := path.Join(dirPath, "encryption.json")
+	metadataBytes, err := ioutil.ReadFile(metadataPath)
+	if os.IsNotExist(err) {
+		if !config.Enabled {
+			return nil, nil
+		}
+
+		secret, err := encryptionSecret(config)
+		if err != nil {
+			return nil, err
+		}
+		historyCipher, err := newHistoryCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		return historyCipher, saveEncryptionMetadata(dirPath, historyCipher)
+	} else if err != nil {
+		return nil, errors.New("Error reading history encryption metadata: " + err.Error())
+	}
+
+	metadata := encryptionMetadata{}
+	err = json.Unmarshal(metadataBytes, &metadata)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling history encryption metadata: " + err.Error())
+	}
+
+	secret, err := encryptionSecret(config)
+	if err != nil {
+		return nil, err
+	}
+	return openHistoryCipher(secret, metadata)
+}
+
+// Save a cipher's key derivation parameters, or remove them when history is not encrypted
+func saveEncryptionMetadata(dirPath string, historyCipher *historyCipher) error {
+	metadataPath := path.Join(dirPath, "encryption.json")
+	if historyCipher == nil {
+		err := os.Remove(metadataPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.New("Error removing history encryption metadata: " + err.Error())
+		}
+		return nil
+	}
+
+	// Replace atomically, so a crash never leaves history without its salt
+	err := writeEncryptionMetadata(metadataPath+".new", historyCipher)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(metadataPath+".new", metadataPath)
+	if err != nil {
+		return errors.New("Error writing history encryption metadata: " + err.Error())
+	}
+	return nil
+}
+
+// Write a cipher's key derivation parameters to a file, synced so it survives a crash
+func writeEncryptionMetadata(filePath string, historyCipher *historyCipher) error {
+	metadataBytes, err := json.Marshal(historyCipher.metadata)
+	if err != nil {
+		return errors.New("Error creating history encryption metadata: " + err.Error())
+	}
+	err = writeSyncedFile(filePath, metadataBytes)
+	if err != nil {
+		return errors.New("Error writing history encryption metadata: " + err.Error())
+	}
+	return nil
+}
+
+// Determine if a history line is encrypted rather than plain json
+func isEncryptedLine(line []byte) bool {
+	return len(line) > 0 && !strings.HasPrefix(string(line), "{")
+}
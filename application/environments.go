@@ -0,0 +1,200 @@
+package application
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Named set of variables for templates, such as the URLs and keys of one deployment
+type Environment struct {
+	Name      string
+	Variables map[string]string
+}
+
+// Request as written before its templates were resolved
+type RequestTemplate struct {
+	URL    string
+	Header http.Header
+	Body   string
+}
+
+// Resolves {{name}} placeholders from variables, the process environment and generators
+type templateResolver struct {
+	environment *Environment
+	session     *Session
+	changed     bool
+}
+
+// Matches a {{name}} placeholder, or the {{{{ escape for a literal {{
+var templateRegexp = regexp.MustCompile(`\{\{\{\{|\{\{\s*([^{}]*?)\s*\}\}`)
+
+//
+//	Private functions
+//
+
+var environmentNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// Load a named environment from the environments directory
+func (app *Application) loadEnvironment(name string) (*Environment, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if !environmentNameRegexp.MatchString(name) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("Invalid environment name '" + name + "'. Use letters, numbers, '.', '_' and '-'.")
+	}
+
+	fileName := name + ".json"
+	jsonBytes, err := ioutil.ReadFile(path.Join(app.EnvironmentsPath, fileName))
+	if os.IsNotExist(err) {
+		return nil, errors.New("No environment named '" + name + "'. Create " + path.Join(app.EnvironmentsPath, fileName) + ".")
+	} else if err != nil {
+		return nil, errors.New("Error reading environment file " + fileName + ": " + err.Error())
+	}
+
+	environment := &Environment{}
+	err = json.Unmarshal(jsonBytes, environment)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling environment " + fileName + ": " + err.Error())
+	}
+	environment.Name = name
+	if environment.Variables == nil {
+		environment.Variables = make(map[string]string)
+	}
+	return environment, nil
+}
+
+// Write an environment back to its file, readably since environments are edited by hand
+func (app *Application) saveEnvironment(environment *Environment) error {
+	jsonBytes, err := json.MarshalIndent(environment, "", "  ")
+	if err != nil {
+		return errors.New("Error creating environment json: " + err.Error())
+	}
+
+	fileName := environment.Name + ".json"
+	err = ioutil.WriteFile(path.Join(app.EnvironmentsPath, fileName), append(jsonBytes, '\n'), 0600)
+	if err != nil {
+		return errors.New("Error writing environment file " + fileName + ": " + err.Error())
+	}
+	return nil
+}
+
+func newTemplateResolver(environment *Environment, session *Session) *templateResolver {
+	return &templateResolver{environment: environment, session: session}
+}
+
+// Replace every placeholder in text; what names the text in errors. Text is
+// only a template when an environment or session is chosen, so other braces pass through.
+func (resolver *templateResolver) resolve(text string, what string) (string, error) {
+	if resolver.environment == nil && resolver.session == nil {
+		return text, nil
+	}
+
+	var resolveErr error
+	resolved := templateRegexp.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if placeholder == "{{{{" {
+			return "{{"
+		}
+		name := templateRegexp.FindStringSubmatch(placeholder)[1]
+		value, err := resolver.value(name)
+		if err != nil && resolveErr == nil {
+			resolveErr = errors.New("Error filling template in " + what + ": " + err.Error())
+		}
+		return value
+	})
+	if resolveErr != nil {
+		return text, resolveErr
+	}
+	if resolved != text {
+		resolver.changed = true
+	}
+	return resolved, nil
+}
+
+// Value of a placeholder: a generator such as $uuid, $env.NAME, or a variable.
+// Session variables, saved by --extract, take precedence over the environment's.
+func (resolver *templateResolver) value(name string) (string, error) {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "", errors.New("Empty placeholder {{}}.")
+	}
+
+	if strings.HasPrefix(fields[0], "$env.") {
+		envName := strings.TrimPrefix(fields[0], "$env.")
+		value, present := os.LookupEnv(envName)
+		if !present {
+			return "", errors.New("Undefined process environment variable '" + envName + "'.")
+		}
+		return value, nil
+	} else if fields[0] == "$uuid" {
+		return newUuid()
+	} else if fields[0] == "$timestamp" {
+		return strconv.FormatInt(time.Now().Unix(), 10), nil
+	} else if fields[0] == "$isoTimestamp" {
+		return time.Now().UTC().Format(time.RFC3339), nil
+	} else if fields[0] == "$randomInt" {
+		return randomInt(fields[1:])
+	} else if strings.HasPrefix(fields[0], "$") {
+		return "", errors.New("Unknown generator '" + fields[0] + "'. Use $uuid, $timestamp, $isoTimestamp, $randomInt or $env.NAME.")
+	}
+
+	if resolver.session != nil {
+		value, present := resolver.session.Variables[name]
+		if present {
+			return value, nil
+		}
+	}
+	if resolver.environment != nil {
+		value, present := resolver.environment.Variables[name]
+		if present {
+			return value, nil
+		}
+		return "", errors.New("Undefined variable '" + name + "' in environment " + resolver.environment.Name + ".")
+	}
+	return "", errors.New("Undefined variable '" + name + "' in session " + resolver.session.Name + ".")
+}
+
+// Random version 4 UUID
+func newUuid() (string, error) {
+	uuid := make([]byte, 16)
+	_, err := rand.Read(uuid)
+	if err != nil {
+		return "", errors.New("Error creating uuid: " + err.Error())
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// Random integer from 0 to 1000, or between the given bounds inclusive
+func randomInt(bounds []string) (string, error) {
+	min, max := big.NewInt(0), big.NewInt(1000)
+	if len(bounds) == 2 {
+		_, minOk := min.SetString(bounds[0], 10)
+		_, maxOk := max.SetString(bounds[1], 10)
+		if !minOk || !maxOk || min.Cmp(max) > 0 {
+			return "", errors.New("Invalid $randomInt bounds '" + strings.Join(bounds, " ") + "'. Expected integers MIN MAX with MIN <= MAX.")
+		}
+	} else if len(bounds) != 0 {
+		return "", errors.New("Expected $randomInt or $randomInt MIN MAX.")
+	}
+
+	// The width is computed in big.Int, where max-min+1 cannot overflow
+	width := new(big.Int).Sub(max, min)
+	width.Add(width, big.NewInt(1))
+	n, err := rand.Int(rand.Reader, width)
+	if err != nil {
+		return "", errors.New("Error creating random number: " + err.Error())
+	}
+	return n.Add(n, min).String(), nil
+}
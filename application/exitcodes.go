@@ -0,0 +1,99 @@
+package application
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Process exit codes
+const (
+	ExitOK                = 0
+	ExitFailure           = 1
+	ExitTimeout           = 2
+	ExitHttp4xx           = 4
+	ExitHttp5xx           = 5
+	ExitDNSFailure        = 6
+	ExitConnectionRefused = 7
+	ExitTLSFailure        = 8
+)
+
+// Descriptions of exit codes for help text, in code order
+var exitCodeDescriptions = []struct {
+	Code        int
+	Description string
+}{
+	{ExitOK, "Success"},
+	{ExitFailure, "General error"},
+	{ExitTimeout, "Request timed out"},
+	{ExitHttp4xx, "HTTP 4xx response (with --check-status)"},
+	{ExitHttp5xx, "HTTP 5xx response (with --check-status)"},
+	{ExitDNSFailure, "Host name could not be resolved"},
+	{ExitConnectionRefused, "Connection refused"},
+	{ExitTLSFailure, "TLS handshake or certificate failure"},
+}
+
+// Error that determines the process exit code
+type ExitError struct {
+	Code    int
+	Message string
+}
+
+func (err *ExitError) Error() string {
+	return err.Message
+}
+
+//
+//	Private functions
+//
+
+// Fail with a distinct exit code on 4xx and 5xx responses if requested
+func (app *Application) checkResponseStatus() error {
+	if !app.Request.CheckStatus {
+		return nil
+	}
+
+	statusCode := app.Response.StatusCode
+	if statusCode >= 400 && statusCode < 500 {
+		return &ExitError{Code: ExitHttp4xx, Message: "Client error response: " + app.Response.Status}
+	} else if statusCode >= 500 && statusCode < 600 {
+		return &ExitError{Code: ExitHttp5xx, Message: "Server error response: " + app.Response.Status}
+	}
+	return nil
+}
+
+// Wrap an error from sending a request with the exit code for its cause
+func newSendError(err error) error {
+	return &ExitError{
+		Code:    sendErrorCode(err),
+		Message: "Error sending request: " + err.Error(),
+	}
+}
+
+// Classify an error returned by http.Client.Do
+func sendErrorCode(err error) int {
+	var dnsErr *net.DNSError
+	var certVerificationErr *tls.CertificateVerificationError
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	var netErr net.Error
+
+	if errors.As(err, &dnsErr) {
+		return ExitDNSFailure
+	} else if errors.As(err, &certVerificationErr) || errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &alertErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return ExitTLSFailure
+	} else if errors.Is(err, syscall.ECONNREFUSED) {
+		return ExitConnectionRefused
+	} else if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ExitTimeout
+	}
+	return ExitFailure
+}
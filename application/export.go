@@ -0,0 +1,253 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Export history records for use by other tools
+func (app *Application) RunHistoryExport(opts *OptionSet) error {
+	format := strings.ToLower(opts.Value("format"))
+	if format != "curl" && format != "har" {
+		return errors.New("Invalid export format '" + format + "'. Expected curl or har.")
+	}
+
+	historyApps, historyIndexes, err := app.selectHistoryApps(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Flag("include-credentials") {
+		for i, j := 0, len(historyApps); i < j; i++ {
+			err = app.loadCredential(historyApps[i].Request.Auth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	output := ""
+	if format == "har" {
+		harBytes, err := json.MarshalIndent(app.newHar(historyApps), "", "  ")
+		if err != nil {
+			return errors.New("Error creating HAR json: " + err.Error())
+		}
+		output = string(harBytes) + "\n"
+	} else {
+		commands := make([]string, 0, len(historyApps))
+		for i, j := 0, len(historyApps); i < j; i++ {
+			comment := "# " + strconv.Itoa(historyIndexes[i]) + ". " + historyApps[i].Id + " " + historyApps[i].Request.Method + " " + historyApps[i].Request.URL.String()
+			commands = append(commands, comment+"\n"+curlCommand(historyApps[i].Request))
+		}
+		output = strings.Join(commands, "\n\n") + "\n"
+	}
+
+	outputFilePath := opts.Value("output")
+	if outputFilePath == "" {
+		fmt.Print(output)
+		return nil
+	}
+
+	err = ioutil.WriteFile(outputFilePath, []byte(output), 0666)
+	if err != nil {
+		return errors.New("Error writing export file " + outputFilePath + ": " + err.Error())
+	}
+	fmt.Println("Exported", len(historyApps), "history records to file: "+outputFilePath)
+	return nil
+}
+
+//
+//	Private functions
+//
+
+// Load history records chosen by index arguments (1, 3-5), record IDs or list filters
+func (app *Application) selectHistoryApps(opts *OptionSet) ([]Application, []int, error) {
+	historyApps := make([]Application, 0)
+	historyIndexes := make([]int, 0)
+	args := opts.Args()
+
+	if len(args) == 0 {
+		query, err := getHistoryQuery(opts)
+		if err != nil {
+			return historyApps, historyIndexes, err
+		}
+		entries, _, _, err := app.history.Query(query)
+		if err != nil {
+			return historyApps, historyIndexes, err
+		}
+
+		// Oldest first, so exported requests run in their original order
+		for i := len(entries) - 1; i >= 0; i-- {
+			historyApp, err := app.history.Load(entries[i])
+			if err != nil {
+				return historyApps, historyIndexes, err
+			}
+			historyApps = append(historyApps, historyApp)
+			historyIndexes = append(historyIndexes, entries[i].Index)
+		}
+		return historyApps, historyIndexes, nil
+	}
+
+	entries, _, _, err := app.history.Query(HistoryQuery{})
+	if err != nil {
+		return historyApps, historyIndexes, err
+	}
+
+	for i, j := 0, len(args); i < j; i++ {
+		if isRecordId(args[i]) {
+			entry, err := app.findHistoryEntry(args[i])
+			if err != nil {
+				return historyApps, historyIndexes, err
+			}
+			historyApp, err := app.history.Load(entry)
+			if err != nil {
+				return historyApps, historyIndexes, err
+			}
+			historyApps = append(historyApps, historyApp)
+			historyIndexes = append(historyIndexes, entry.Index)
+			continue
+		}
+
+		first, last, err := parseIndexRange(args[i])
+		if err != nil {
+			return historyApps, historyIndexes, err
+		}
+
+		step := 1
+		if first > last {
+			step = -1
+		}
+		for index := first; ; index += step {
+			if index < 1 || index > len(entries) || entries[index-1].Index != index {
+				return historyApps, historyIndexes, errors.New("Invalid history record index: " + strconv.Itoa(index))
+			}
+			historyApp, err := app.history.Load(entries[index-1])
+			if err != nil {
+				return historyApps, historyIndexes, err
+			}
+			historyApps = append(historyApps, historyApp)
+			historyIndexes = append(historyIndexes, index)
+
+			if index == last {
+				break
+			}
+		}
+	}
+	return historyApps, historyIndexes, nil
+}
+
+// Parse a history index argument, either "3" or "3-5"
+func parseIndexRange(arg string) (int, int, error) {
+	parts := strings.SplitN(arg, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errors.New("Invalid history record index: " + arg)
+	}
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+
+	last, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errors.New("Invalid history record range: " + arg)
+	}
+	return first, last, nil
+}
+
+// Render a request as a curl command line
+func curlCommand(request Request) string {
+	parts := []string{"curl"}
+	if request.Method == "HEAD" {
+		parts = append(parts, "--head")
+	} else if request.Method != "GET" || len(request.Body) > 0 {
+		parts = append(parts, "-X "+request.Method)
+	}
+	parts = append(parts, shellQuote(request.URL.String()))
+
+	if request.ContentType != "" && request.Header.Get("Content-Type") == "" {
+		parts = append(parts, "-H "+shellQuote("Content-Type: "+request.ContentType))
+	}
+	if request.Accept != "" && request.Header.Get("Accept") == "" {
+		parts = append(parts, "-H "+shellQuote("Accept: "+request.Accept))
+	}
+
+	keys := make([]string, 0, len(request.Header))
+	for key := range request.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, j := 0, len(keys); i < j; i++ {
+		values := request.Header[keys[i]]
+		for k, l := 0, len(values); k < l; k++ {
+			parts = append(parts, "-H "+shellQuote(keys[i]+": "+values[k]))
+		}
+	}
+
+	if request.Auth != nil {
+		if request.Auth.Type == "bearer" {
+			parts = append(parts, "-H "+shellQuote("Authorization: Bearer "+request.Auth.Token))
+		} else {
+			if request.Auth.Type == "digest" {
+				parts = append(parts, "--digest")
+			}
+			parts = append(parts, "-u "+shellQuote(request.Auth.Username+":"+request.Auth.Password))
+		}
+	}
+
+	// The timeout is not exported: it limits the wait for response headers, and curl's
+	// --max-time limits the whole transfer, which would fail slow downloads.
+	// Arguments cannot hold NUL bytes, so such a body is piped to curl instead.
+	if bytes.IndexByte(request.Body, 0) > -1 {
+		parts[0] = "printf " + printfQuote(request.Body) + " | curl"
+		parts = append(parts, "--data-binary @-")
+	} else if len(request.Body) > 0 {
+		parts = append(parts, "--data-binary "+shellQuote(string(request.Body)))
+	}
+
+	return strings.Join(parts, " \\\n  ")
+}
+
+// Quote data as a printf format that prints it exactly, escaping bytes other than printable ASCII
+func printfQuote(data []byte) string {
+	quoted := "'"
+	for i, j := 0, len(data); i < j; i++ {
+		c := data[i]
+		if c == '%' {
+			quoted += "%%"
+		} else if c == '\\' {
+			quoted += `\\`
+		} else if c != '\'' && c >= 0x20 && c < 0x7f {
+			quoted += string(c)
+		} else {
+			quoted += fmt.Sprintf(`\%03o`, c)
+		}
+	}
+	return quoted + "'"
+}
+
+// Quote a string for POSIX shells, using ANSI-C quoting only when it holds binary data
+func shellQuote(s string) string {
+	if utf8.ValidString(s) && strings.IndexByte(s, 0) < 0 {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+
+	quoted := "$'"
+	for i, j := 0, len(s); i < j; i++ {
+		c := s[i]
+		if c == '\'' || c == '\\' {
+			quoted += `\` + string(c)
+		} else if c >= 0x20 && c < 0x7f {
+			quoted += string(c)
+		} else {
+			quoted += fmt.Sprintf(`\x%02x`, c)
+		}
+	}
+	return quoted + "'"
+}
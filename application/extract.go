@@ -0,0 +1,226 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value to take from a response into a variable, given as NAME=$.json.path,
+// NAME=header:Header-Name or NAME=regex:PATTERN
+type responseExtractor struct {
+	Spec     string
+	Name     string
+	JsonPath []string
+	Header   string
+	Regexp   *regexp.Regexp
+}
+
+//
+//	Private functions
+//
+
+// Matches variable names that templates can refer to
+var variableNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_.-]*$")
+
+// Check a request's --extract options, which need a session or environment to save to
+func validateExtract(request *Request) error {
+	if len(request.Extract) == 0 {
+		return nil
+	}
+	if request.Session == "" && request.Environment == "" {
+		return errors.New("--extract saves variables to the session given by --session or the environment given by --env.")
+	}
+	_, err := parseExtractors(request.Extract)
+	return err
+}
+
+func parseExtractors(specs []string) ([]*responseExtractor, error) {
+	extractors := make([]*responseExtractor, len(specs))
+	for i, j := 0, len(specs); i < j; i++ {
+		extractor, err := parseExtractor(specs[i])
+		if err != nil {
+			return nil, err
+		}
+		extractors[i] = extractor
+	}
+	return extractors, nil
+}
+
+func parseExtractor(spec string) (*responseExtractor, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		return nil, errors.New("Invalid --extract '" + spec + "'. Expected NAME=$.json.path, NAME=header:NAME or NAME=regex:PATTERN.")
+	}
+	name, source := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if !variableNameRegexp.MatchString(name) {
+		return nil, errors.New("Invalid variable name '" + name + "' in --extract. Use letters, numbers, '.', '_' and '-'.")
+	}
+
+	extractor := &responseExtractor{Spec: spec, Name: name}
+	if strings.HasPrefix(source, "header:") {
+		extractor.Header = strings.TrimSpace(strings.TrimPrefix(source, "header:"))
+		if extractor.Header == "" {
+			return nil, errors.New("Missing header name in --extract '" + spec + "'.")
+		}
+	} else if strings.HasPrefix(source, "regex:") {
+		pattern, err := regexp.Compile(strings.TrimPrefix(source, "regex:"))
+		if err != nil {
+			return nil, errors.New("Invalid regular expression in --extract '" + spec + "': " + err.Error())
+		}
+		extractor.Regexp = pattern
+	} else {
+		jsonPath, err := parseJsonPath(source)
+		if err != nil {
+			return nil, err
+		}
+		extractor.JsonPath = jsonPath
+	}
+	return extractor, nil
+}
+
+// Take the value an extractor selects from a response. A regex gives its first group,
+// or the whole match without groups, and a JSON path its first match.
+func (extractor *responseExtractor) extract(response *Response) (string, error) {
+	if extractor.Header != "" {
+		values := response.Header.Values(extractor.Header)
+		if len(values) == 0 {
+			return "", errors.New("no " + extractor.Header + " header in the response")
+		}
+		return values[0], nil
+	} else if extractor.Regexp != nil {
+		match := extractor.Regexp.FindSubmatch(response.Body)
+		if match == nil {
+			return "", errors.New("the pattern did not match the response body")
+		} else if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+
+	value, err := decodeJsonValue(response.Body)
+	if err != nil {
+		return "", errors.New("the response body is not JSON")
+	}
+	matches := findJsonValues(value, extractor.JsonPath)
+	if len(matches) == 0 {
+		return "", errors.New("the path did not match the response body")
+	}
+	if text, isString := matches[0].(string); isString {
+		return text, nil
+	}
+	return formatJsonValue(matches[0]), nil
+}
+
+// Values at a parsed JSON path, in document order with object keys sorted
+func findJsonValues(value interface{}, segments []string) []interface{} {
+	if len(segments) == 0 {
+		return []interface{}{value}
+	}
+
+	matches := make([]interface{}, 0)
+	segment := segments[0]
+	if segment == "**" {
+		matches = append(matches, findJsonValues(value, segments[1:])...)
+		children := jsonChildren(value)
+		for i, j := 0, len(children); i < j; i++ {
+			matches = append(matches, findJsonValues(children[i], segments)...)
+		}
+	} else if segment == "*" {
+		children := jsonChildren(value)
+		for i, j := 0, len(children); i < j; i++ {
+			matches = append(matches, findJsonValues(children[i], segments[1:])...)
+		}
+	} else if array, isArray := value.([]interface{}); isArray && strings.HasPrefix(segment, "[") {
+		// Negative indexes count from the end, so [-1] is the last element
+		index, _ := strconv.Atoi(strings.Trim(segment, "[]"))
+		if index < 0 {
+			index += len(array)
+		}
+		if index >= 0 && index < len(array) {
+			matches = append(matches, findJsonValues(array[index], segments[1:])...)
+		}
+	} else if object, isObject := value.(map[string]interface{}); isObject {
+		if child, present := object[segment]; present {
+			matches = append(matches, findJsonValues(child, segments[1:])...)
+		}
+	}
+	return matches
+}
+
+func jsonChildren(value interface{}) []interface{} {
+	if array, isArray := value.([]interface{}); isArray {
+		return array
+	}
+	object, isObject := value.(map[string]interface{})
+	if !isObject {
+		return nil
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]interface{}, len(keys))
+	for i, j := 0, len(keys); i < j; i++ {
+		children[i] = object[keys[i]]
+	}
+	return children
+}
+
+// Save values from the response as variables in the request's session, or its environment
+// without a session. Values that cannot be found are reported and left unchanged.
+func (app *Application) extractVariables() error {
+	if len(app.Request.Extract) == 0 {
+		return nil
+	}
+	extractors, err := parseExtractors(app.Request.Extract)
+	if err != nil {
+		return err
+	}
+
+	variables := make(map[string]string)
+	names := make([]string, 0, len(extractors))
+	for i, j := 0, len(extractors); i < j; i++ {
+		value, err := extractors[i].extract(&app.Response)
+		if err != nil {
+			fmt.Println("Warning: could not extract " + extractors[i].Spec + ": " + err.Error() + ".")
+			continue
+		}
+		if _, present := variables[extractors[i].Name]; !present {
+			names = append(names, extractors[i].Name)
+		}
+		variables[extractors[i].Name] = value
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if app.session != nil {
+		if app.session.Variables == nil {
+			app.session.Variables = make(map[string]string)
+		}
+		for name, value := range variables {
+			app.session.Variables[name] = value
+		}
+		fmt.Println("Saved to session " + app.session.Name + ": " + strings.Join(names, ", "))
+		return nil
+	}
+
+	environment, err := app.loadEnvironment(app.Request.Environment)
+	if err != nil {
+		return err
+	}
+	for name, value := range variables {
+		environment.Variables[name] = value
+	}
+	err = app.saveEnvironment(environment)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Saved to environment " + environment.Name + ": " + strings.Join(names, ", "))
+	return nil
+}
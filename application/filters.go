@@ -0,0 +1,134 @@
+package application
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matches one number and unit of a duration such as 1w2d or 1h30m
+var durationPartRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ns|us|µs|ms|s|m|h|d|w)`)
+
+// Time formats accepted by --since and --until, besides relative durations
+var filterTimeFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+//
+//	Private functions
+//
+
+// Apply structured filter options to a history query
+func addHistoryQueryFilters(query *HistoryQuery, opts *OptionSet, now time.Time) error {
+	query.Method = opts.Value("method")
+	query.Host = opts.Value("host")
+	query.BodyContains = opts.Value("body-contains")
+
+	var err error
+	if opts.Value("status") != "" {
+		query.StatusMin, query.StatusMax, err = parseStatusFilter(opts.Value("status"))
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Value("since") != "" {
+		query.Since, err = parseFilterTime(opts.Value("since"), now)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Value("until") != "" {
+		query.Until, err = parseFilterTime(opts.Value("until"), now)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Value("min-duration") != "" {
+		query.MinDuration, err = parseLongDuration(opts.Value("min-duration"))
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Value("regex") != "" {
+		query.URLRegexp, err = regexp.Compile(opts.Value("regex"))
+		if err != nil {
+			return errors.New("Invalid --regex pattern: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Parse a status filter: an exact code (404), a class (5xx) or a range (400-499)
+func parseStatusFilter(value string) (int, int, error) {
+	lowerValue := strings.ToLower(value)
+	if len(lowerValue) == 3 && strings.HasSuffix(lowerValue, "xx") {
+		class, err := strconv.Atoi(lowerValue[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return class * 100, class*100 + 99, nil
+		}
+	} else if parts := strings.SplitN(lowerValue, "-", 2); len(parts) == 2 {
+		first, err1 := strconv.Atoi(parts[0])
+		last, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && first > 0 && first <= last {
+			return first, last, nil
+		}
+	} else {
+		code, err := strconv.Atoi(lowerValue)
+		if err == nil && code > 0 {
+			return code, code, nil
+		}
+	}
+	return 0, 0, errors.New("Invalid status filter '" + value + "'. Expected a code (404), class (5xx) or range (400-499).")
+}
+
+// Parse a time filter, either a duration before now (2h, 3d) or a date and time
+func parseFilterTime(value string, now time.Time) (time.Time, error) {
+	duration, err := parseLongDuration(value)
+	if err == nil {
+		return now.Add(-duration), nil
+	}
+
+	for i, j := 0, len(filterTimeFormats); i < j; i++ {
+		t, err := time.ParseInLocation(filterTimeFormats[i], value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid time '" + value + "'. Expected a duration (2h, 3d) or a date (2006-01-02 15:04:05).")
+}
+
+// Parse a duration like time.ParseDuration, also accepting days (d) and weeks (w)
+func parseLongDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("Invalid duration: empty value.")
+	}
+
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		match := durationPartRegexp.FindStringSubmatch(rest)
+		if match == nil {
+			return 0, errors.New("Invalid duration '" + value + "'.")
+		}
+		rest = rest[len(match[0]):]
+
+		if match[2] == "d" || match[2] == "w" {
+			number, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				return 0, errors.New("Invalid duration '" + value + "'.")
+			}
+			unit := 24 * time.Hour
+			if match[2] == "w" {
+				unit = 7 * unit
+			}
+			total += time.Duration(number * float64(unit))
+		} else {
+			duration, err := time.ParseDuration(match[0])
+			if err != nil {
+				return 0, errors.New("Invalid duration '" + value + "'.")
+			}
+			total += duration
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,401 @@
+package application
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/
+type Har struct {
+	Log HarLog `json:"log"`
+}
+
+type HarLog struct {
+	Version string     `json:"version"`
+	Creator HarCreator `json:"creator"`
+	Entries []HarEntry `json:"entries"`
+}
+
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HarEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+}
+
+type HarRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	QueryString []HarNameValue `json:"queryString"`
+	PostData    *HarPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HarResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	Content     HarContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HarCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type HarNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HarContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HarTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+//
+//	Private functions
+//
+
+// Build a HAR document from history records
+func (app *Application) newHar(historyApps []Application) Har {
+	har := Har{
+		Log: HarLog{
+			Version: "1.2",
+			Creator: HarCreator{Name: app.Name, Version: app.Version},
+			Entries: make([]HarEntry, 0, len(historyApps)),
+		},
+	}
+	for i, j := 0, len(historyApps); i < j; i++ {
+		har.Log.Entries = append(har.Log.Entries, newHarEntry(historyApps[i]))
+	}
+	return har
+}
+
+// Convert a history record to a HAR entry
+func newHarEntry(historyApp Application) HarEntry {
+	request := historyApp.Request
+	response := historyApp.Response
+	timings := response.Timings
+
+	requestHeader := request.fullHeader()
+	if request.Auth != nil && (request.Auth.Password == redactedValue || request.Auth.Token == redactedValue) {
+		requestHeader.Set("Authorization", redactedValue)
+	}
+
+	entry := HarEntry{
+		StartedDateTime: historyApp.StartTime.Format(time.RFC3339Nano),
+		Time:            milliseconds(historyApp.Duration),
+		Request: HarRequest{
+			Method:      request.Method,
+			URL:         request.URL.String(),
+			HttpVersion: "HTTP/1.1",
+			Cookies:     harCookies((&http.Request{Header: requestHeader}).Cookies()),
+			Headers:     harHeaders(requestHeader),
+			QueryString: harQueryString(request.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    len(request.Body),
+		},
+		Response: HarResponse{
+			Status:      response.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(response.Status, fmt.Sprint(response.StatusCode))),
+			HttpVersion: response.Proto,
+			Cookies:     harCookies((&http.Response{Header: response.Header}).Cookies()),
+			Headers:     harHeaders(response.Header),
+			Content: HarContent{
+				Size:     response.ContentLength,
+				MimeType: response.ContentType,
+			},
+			RedirectURL: response.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    response.ContentLength,
+		},
+		Timings: HarTimings{
+			Blocked: milliseconds(timings.Blocked),
+			DNS:     milliseconds(timings.DNS),
+			Connect: milliseconds(timings.Connect + timings.SSL),
+			Send:    milliseconds(timings.Send),
+			Wait:    milliseconds(timings.Wait),
+			Receive: milliseconds(timings.Receive),
+			SSL:     milliseconds(timings.SSL),
+		},
+	}
+	if entry.Response.HttpVersion == "" {
+		entry.Response.HttpVersion = "HTTP/1.1"
+	}
+	if entry.Timings.Send+entry.Timings.Wait+entry.Timings.Receive == 0 {
+		// Records from before timings were kept count the whole duration as waiting
+		entry.Timings.Wait = entry.Time
+	}
+
+	if len(request.Body) > 0 {
+		text, encoding := harText(request.Body)
+		entry.Request.PostData = &HarPostData{MimeType: request.ContentType, Text: text, Encoding: encoding}
+	}
+	if len(response.Body) > 0 {
+		entry.Response.Content.Text, entry.Response.Content.Encoding = harText(response.Body)
+	}
+	return entry
+}
+
+// Convert a HAR entry to a history record
+func (app *Application) newAppFromHarEntry(entry HarEntry) (Application, error) {
+	historyApp := Application{
+		Name:           app.Name,
+		Version:        app.Version,
+		Commands:       app.Commands,
+		RequestMethods: app.RequestMethods,
+		Args:           app.Args,
+		Mode:           "import",
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime)
+	if err != nil {
+		return historyApp, errors.New("Invalid HAR startedDateTime '" + entry.StartedDateTime + "': " + err.Error())
+	}
+	historyApp.StartTime = startTime
+	historyApp.Duration = time.Duration(entry.Time * float64(time.Millisecond))
+	historyApp.EndTime = startTime.Add(historyApp.Duration)
+
+	requestUrl, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return historyApp, errors.New("Error parsing HAR request URL: " + err.Error())
+	}
+
+	requestHeader := http.Header{}
+	for i, j := 0, len(entry.Request.Headers); i < j; i++ {
+		name := entry.Request.Headers[i].Name
+		lowerName := strings.ToLower(name)
+		// HTTP/2 pseudo headers and connection headers are set by the transport
+		if strings.HasPrefix(name, ":") || lowerName == "host" || lowerName == "content-length" || lowerName == "connection" {
+			continue
+		}
+		requestHeader.Add(name, entry.Request.Headers[i].Value)
+	}
+	request := Request{
+		Method:      strings.ToUpper(entry.Request.Method),
+		URL:         requestUrl,
+		Timeout:     60,
+		ContentType: requestHeader.Get("Content-Type"),
+		Accept:      requestHeader.Get("Accept"),
+	}
+	requestHeader.Del("Content-Type")
+	requestHeader.Del("Accept")
+	request.Header = requestHeader
+	if entry.Request.PostData != nil {
+		request.Body, err = harBody(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+		if err != nil {
+			return historyApp, err
+		}
+		if request.ContentType == "" {
+			request.ContentType = entry.Request.PostData.MimeType
+		}
+	}
+	request.ContentLength = len(request.Body)
+	historyApp.Request = request
+
+	responseHeader := http.Header{}
+	for i, j := 0, len(entry.Response.Headers); i < j; i++ {
+		responseHeader.Add(entry.Response.Headers[i].Name, entry.Response.Headers[i].Value)
+	}
+	responseBody, err := harBody(entry.Response.Content.Text, entry.Response.Content.Encoding)
+	if err != nil {
+		return historyApp, err
+	}
+	historyApp.Response = Response{
+		StatusCode:    entry.Response.Status,
+		Status:        strings.TrimSpace(fmt.Sprint(entry.Response.Status) + " " + entry.Response.StatusText),
+		Proto:         entry.Response.HttpVersion,
+		Header:        responseHeader,
+		ContentType:   entry.Response.Content.MimeType,
+		ContentLength: len(responseBody),
+		Body:          responseBody,
+		Timings: Timings{
+			Blocked: harDuration(entry.Timings.Blocked),
+			DNS:     harDuration(entry.Timings.DNS),
+			Connect: harDuration(entry.Timings.Connect) - harDuration(entry.Timings.SSL),
+			SSL:     harDuration(entry.Timings.SSL),
+			Send:    harDuration(entry.Timings.Send),
+			Wait:    harDuration(entry.Timings.Wait),
+			Receive: harDuration(entry.Timings.Receive),
+		},
+	}
+	return historyApp, nil
+}
+
+// Import HAR entries as history records
+func (app *Application) importHar(opts *OptionSet) error {
+	args := opts.Args()
+	var harBytes []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		harBytes, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		harBytes, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		return errors.New("Error reading HAR file: " + err.Error())
+	}
+
+	har := Har{}
+	err = json.Unmarshal(harBytes, &har)
+	if err != nil {
+		return errors.New("Error unmarshalling HAR json: " + err.Error())
+	}
+
+	historyApps := make([]Application, 0, len(har.Log.Entries))
+	for i, j := 0, len(har.Log.Entries); i < j; i++ {
+		historyApp, err := app.newAppFromHarEntry(har.Log.Entries[i])
+		if err != nil {
+			return err
+		}
+		historyApps = append(historyApps, historyApp)
+	}
+
+	// Save in chronological order so history indexes follow the original order
+	sort.SliceStable(historyApps, func(a int, b int) bool {
+		return historyApps[a].StartTime.Before(historyApps[b].StartTime)
+	})
+	for i, j := 0, len(historyApps); i < j; i++ {
+		_, err = app.history.Append(&historyApps[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Imported", len(historyApps), "HAR entries into history.")
+	return nil
+}
+
+func harHeaders(header http.Header) []HarNameValue {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]HarNameValue, 0, len(header))
+	for i, j := 0, len(keys); i < j; i++ {
+		values := header[keys[i]]
+		for k, l := 0, len(values); k < l; k++ {
+			pairs = append(pairs, HarNameValue{Name: keys[i], Value: values[k]})
+		}
+	}
+	return pairs
+}
+
+func harQueryString(query url.Values) []HarNameValue {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]HarNameValue, 0, len(query))
+	for i, j := 0, len(keys); i < j; i++ {
+		values := query[keys[i]]
+		for k, l := 0, len(values); k < l; k++ {
+			pairs = append(pairs, HarNameValue{Name: keys[i], Value: values[k]})
+		}
+	}
+	return pairs
+}
+
+func harCookies(cookies []*http.Cookie) []HarCookie {
+	harCookies := make([]HarCookie, 0, len(cookies))
+	for i, j := 0, len(cookies); i < j; i++ {
+		cookie := HarCookie{
+			Name:     cookies[i].Name,
+			Value:    cookies[i].Value,
+			Path:     cookies[i].Path,
+			Domain:   cookies[i].Domain,
+			HttpOnly: cookies[i].HttpOnly,
+			Secure:   cookies[i].Secure,
+		}
+		if !cookies[i].Expires.IsZero() {
+			cookie.Expires = cookies[i].Expires.Format(time.RFC3339)
+		}
+		harCookies = append(harCookies, cookie)
+	}
+	return harCookies
+}
+
+// Body as HAR text, base64 encoded when it is not valid UTF-8
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harBody(text string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		body, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return body, errors.New("Error decoding base64 HAR content: " + err.Error())
+		}
+		return body, nil
+	}
+	return []byte(text), nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// HAR uses -1 for timings that do not apply
+func harDuration(ms float64) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -0,0 +1,152 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHarRoundTrip(t *testing.T) {
+	app := &Application{Name: "gohttp", Version: "test"}
+	startTime := time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC)
+	getUrl, _ := url.Parse("https://example.com/items?page=2")
+	postUrl, _ := url.Parse("https://example.com/upload")
+	historyApps := []Application{
+		{
+			StartTime: startTime,
+			Duration:  250 * time.Millisecond,
+			Request: Request{
+				Method: "GET",
+				URL:    getUrl,
+				Header: http.Header{"X-Trace": {"1", "2"}},
+				Accept: "application/json",
+			},
+			Response: Response{
+				StatusCode:    200,
+				Status:        "200 OK",
+				Proto:         "HTTP/2.0",
+				Header:        http.Header{"Content-Type": {"application/json"}},
+				ContentType:   "application/json",
+				Body:          []byte(`{"items": []}`),
+				ContentLength: 13,
+				Timings: Timings{
+					DNS:     5 * time.Millisecond,
+					Connect: 10 * time.Millisecond,
+					SSL:     20 * time.Millisecond,
+					Send:    time.Millisecond,
+					Wait:    200 * time.Millisecond,
+					Receive: 14 * time.Millisecond,
+				},
+			},
+		},
+		{
+			StartTime: startTime.Add(time.Second),
+			Duration:  time.Second,
+			Request: Request{
+				Method:        "POST",
+				URL:           postUrl,
+				Header:        http.Header{},
+				ContentType:   "application/octet-stream",
+				Body:          []byte{0, 1, 0xff},
+				ContentLength: 3,
+			},
+			Response: Response{
+				StatusCode:    201,
+				Status:        "201 Created",
+				Header:        http.Header{"Location": {"/upload/1"}},
+				ContentType:   "text/plain",
+				Body:          []byte("created"),
+				ContentLength: 7,
+			},
+		},
+	}
+
+	// The HAR goes through JSON, as it does between export and import
+	harBytes, err := json.Marshal(app.newHar(historyApps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	har := Har{}
+	err = json.Unmarshal(harBytes, &har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != len(historyApps) {
+		t.Fatalf("HAR has %d entries, expected %d", len(har.Log.Entries), len(historyApps))
+	}
+
+	for i, j := 0, len(historyApps); i < j; i++ {
+		expected := historyApps[i]
+		imported, err := app.newAppFromHarEntry(har.Log.Entries[i])
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+
+		tests := []struct {
+			what     string
+			value    interface{}
+			expected interface{}
+		}{
+			{"mode", imported.Mode, "import"},
+			{"start time", imported.StartTime.Equal(expected.StartTime), true},
+			{"duration", imported.Duration, expected.Duration},
+			{"method", imported.Request.Method, expected.Request.Method},
+			{"url", imported.Request.URL.String(), expected.Request.URL.String()},
+			{"request header", imported.Request.Header, expected.Request.Header},
+			{"accept", imported.Request.Accept, expected.Request.Accept},
+			{"request content type", imported.Request.ContentType, expected.Request.ContentType},
+			{"request body", string(imported.Request.Body), string(expected.Request.Body)},
+			{"request content length", imported.Request.ContentLength, expected.Request.ContentLength},
+			{"status code", imported.Response.StatusCode, expected.Response.StatusCode},
+			{"status", imported.Response.Status, expected.Response.Status},
+			{"response header", imported.Response.Header, expected.Response.Header},
+			{"response content type", imported.Response.ContentType, expected.Response.ContentType},
+			{"response body", string(imported.Response.Body), string(expected.Response.Body)},
+			{"response content length", imported.Response.ContentLength, expected.Response.ContentLength},
+		}
+		for k, l := 0, len(tests); k < l; k++ {
+			if !reflect.DeepEqual(tests[k].value, tests[k].expected) {
+				t.Errorf("entry %d %s: got %#v, expected %#v", i, tests[k].what, tests[k].value, tests[k].expected)
+			}
+		}
+	}
+
+	// Phase timings survive the round trip; HAR counts the TLS handshake within connect
+	imported, _ := app.newAppFromHarEntry(har.Log.Entries[0])
+	if !reflect.DeepEqual(imported.Response.Timings, historyApps[0].Response.Timings) {
+		t.Errorf("timings = %+v, expected %+v", imported.Response.Timings, historyApps[0].Response.Timings)
+	}
+	if har.Log.Entries[0].Timings.Connect != 30 {
+		t.Errorf("HAR connect = %v, expected 30", har.Log.Entries[0].Timings.Connect)
+	}
+	// Records without timings count their whole duration as waiting
+	if har.Log.Entries[1].Timings.Wait != 1000 {
+		t.Errorf("HAR wait = %v, expected 1000", har.Log.Entries[1].Timings.Wait)
+	}
+	if har.Log.Entries[1].Request.PostData.Encoding != "base64" {
+		t.Errorf("binary request body was not base64 encoded")
+	}
+}
+
+func TestNewAppFromHarEntryErrors(t *testing.T) {
+	app := &Application{}
+	tests := []struct {
+		name  string
+		entry HarEntry
+	}{
+		{"bad start time", HarEntry{StartedDateTime: "yesterday"}},
+		{"bad url", HarEntry{StartedDateTime: "2024-01-02T03:04:05Z", Request: HarRequest{URL: "http://a b/%zz"}}},
+		{"bad base64", HarEntry{StartedDateTime: "2024-01-02T03:04:05Z", Request: HarRequest{URL: "http://a"},
+			Response: HarResponse{Content: HarContent{Text: "!!", Encoding: "base64"}}}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		_, err := app.newAppFromHarEntry(tests[i].entry)
+		if err == nil {
+			t.Errorf("%s: expected an error", tests[i].name)
+		}
+	}
+}
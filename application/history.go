@@ -4,21 +4,148 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// History subcommands, the first being the default
+var historyModes = []string{"list", "detail", "replay", "save", "name", "export", "diff", "delete", "clear", "prune", "rekey"}
+
+// Options for a history subcommand
+func newHistoryOptionSet(mode string) *OptionSet {
+	var opts *OptionSet
+	if mode == "detail" {
+		opts = NewOptionSet("History Detail", "history detail [1 | ID] FLAGS")
+		opts.Bool("", "headers", "Show request and response headers")
+		opts.Bool("", "body", "Show the response body")
+		opts.Bool("", "request-body", "Show the request body")
+		opts.Bool("", "raw", "Show bodies exactly as received, without formatting")
+		opts.Bool("", "json", "Show the full record as json")
+	} else if mode == "replay" {
+		opts = NewOptionSet("History Replay", "history replay [1 | ID | FILTERS] FLAGS")
+		addReplayOverrideOptions(opts)
+		opts.Int("", "parallel", "N", 1, "Replay up to N filtered records at once")
+		addHistoryFilterOptions(opts)
+		opts.Bool("", "check-status", "Exit with an error code on 4xx and 5xx responses")
+		opts.String("", "session", "NAME", "", "Replay in a named session")
+		opts.Bool("", "reuse-session", "Replay in the session the request was recorded in")
+	} else if mode == "save" {
+		opts = NewOptionSet("History Save", "history save [1 | ID] /path/to/output/file.json")
+	} else if mode == "name" {
+		opts = NewOptionSet("History Name", "history name [1 | ID] NAME")
+	} else if mode == "export" {
+		opts = NewOptionSet("History Export", "history export [1 | 1-5 | ID] FLAGS")
+		opts.String("", "format", "curl|har", "curl", "Export format")
+		opts.String("o", "output", "/path/to/output/file.sh", "", "Write export to file instead of console")
+		opts.Bool("", "include-credentials", "Include stored passwords and tokens")
+		addHistoryFilterOptions(opts)
+	} else if mode == "diff" {
+		opts = NewOptionSet("History Diff", "history diff [1 | ID] [2 | ID] FLAGS")
+		opts.List("", "ignore", "$.data[*].updatedAt", "Ignore a JSON body path; ..key matches key at any depth")
+		opts.List("", "ignore-header", "Date", "Ignore a response header")
+	} else if mode == "delete" {
+		opts = NewOptionSet("History Delete", "history delete [1 | ID] ...")
+	} else if mode == "clear" {
+		opts = NewOptionSet("History Clear", "history clear FLAGS")
+		opts.Bool("y", "yes", "Clear without asking for confirmation")
+	} else if mode == "prune" {
+		opts = NewOptionSet("History Prune", "history prune FLAGS")
+		opts.String("", "older-than", "30d", "", "Delete records older than duration")
+		opts.Int("", "max-records", "N", 0, "Keep at most N of the newest records")
+		opts.String("", "max-size", "500MB", "", "Keep the newest records within total size")
+	} else if mode == "rekey" {
+		opts = NewOptionSet("History Rekey", "history rekey FLAGS")
+		opts.String("", "passphrase-env", "NAME", "", "Encrypt with the passphrase in environment variable NAME")
+		opts.String("", "key-file", "/path/to/key", "", "Encrypt with a key file")
+		opts.Bool("", "decrypt", "Store history unencrypted")
+	} else {
+		opts = NewOptionSet("History", "history [list] FLAGS")
+		opts.String("", "columns", "index,id,...", defaultHistoryColumns, "Comma separated columns to show; host is also available")
+		opts.String("", "output", "table|json|csv", "table", "List format")
+		addHistoryFilterOptions(opts)
+	}
+	return opts
+}
+
+// Options selecting history records by filter and page
+func addHistoryFilterOptions(opts *OptionSet) {
+	opts.String("f", "find", "GET", "", "Only show records whose method or URL contains text")
+	opts.Bool("i", "insensitive", "Make --find case insensitive")
+	opts.String("", "method", "METHOD", "", "Only show records with request method")
+	// Replay uses --host to override the host instead
+	if opts.lookup("host", false) == nil {
+		opts.String("", "host", "HOST", "", "Only show records sent to host, with or without port")
+	}
+	opts.String("", "status", "404|5xx|400-499", "", "Only show records with response status")
+	opts.String("", "since", "2h|2006-01-02", "", "Only show records started at or after time")
+	opts.String("", "until", "1d|2006-01-02", "", "Only show records started at or before time")
+	opts.String("", "min-duration", "500ms", "", "Only show records that took at least duration")
+	opts.String("", "regex", "PATTERN", "", "Only show records whose URL matches regular expression")
+	opts.String("", "body-contains", "TEXT", "", "Only show records whose request or response body contains text")
+	opts.Int("l", "limit", "N", 10, "Number of records to show")
+	opts.Int("s", "skip", "N", 0, "Number of records to skip")
+}
+
 // Show details of history request/response
-func (app *Application) RunHistoryDetail() error {
-	historyApp, err := app.loadAppFromHistory()
+func (app *Application) RunHistoryDetail(opts *OptionSet) error {
+	showHeaders, showBody, showRequestBody := opts.Flag("headers"), opts.Flag("body"), opts.Flag("request-body")
+	if opts.Flag("json") && (showHeaders || showBody || showRequestBody || opts.Flag("raw")) {
+		return errors.New("Use --json without --headers, --body, --request-body or --raw.")
+	}
+
+	historyApp, err := app.loadAppFromHistory(opts.Args())
 	if err != nil {
 		return err
 	}
 
+	if opts.Flag("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(historyApp)
+		if err != nil {
+			return errors.New("Error creating history record json: " + err.Error())
+		}
+		return nil
+	}
+
+	// A single body is shown alone, so it can be piped
+	if showBody != showRequestBody && !showHeaders {
+		if showBody {
+			printBody(historyApp.Response.Body, historyApp.Response.ContentType, opts.Flag("raw"))
+		} else {
+			printBody(historyApp.Request.Body, historyApp.Request.ContentType, opts.Flag("raw"))
+		}
+		return nil
+	}
+
+	if showHeaders || showBody || showRequestBody {
+		if showHeaders {
+			fmt.Println("Request Headers:")
+			printHeader(historyApp.Request.Header)
+			fmt.Println("Response Headers:")
+			printHeader(historyApp.Response.Header)
+		}
+		if showRequestBody {
+			fmt.Println("Request Body:")
+			printBody(historyApp.Request.Body, historyApp.Request.ContentType, opts.Flag("raw"))
+		}
+		if showBody {
+			fmt.Println("Response Body:")
+			printBody(historyApp.Response.Body, historyApp.Response.ContentType, opts.Flag("raw"))
+		}
+		return nil
+	}
+
+	fmt.Println("Id:", historyApp.Id)
+	if historyApp.ReplayOf != "" {
+		fmt.Println("Replay Of:", historyApp.ReplayOf)
+	}
 	fmt.Println("Name:", historyApp.Name)
 	fmt.Println("Version:", historyApp.Version)
 	fmt.Println("Args:", historyApp.Args)
@@ -34,21 +161,60 @@ func (app *Application) RunHistoryDetail() error {
 	fmt.Println("Request Timeout:", historyApp.Request.Timeout)
 	fmt.Println("Request Content Type:", historyApp.Request.ContentType)
 	fmt.Println("Request Accept:", historyApp.Request.Accept)
+	if historyApp.Request.Auth != nil {
+		fmt.Println("Request Auth:", historyApp.Request.Auth.Type, historyApp.Request.Auth.Username)
+	}
+	if historyApp.Request.Session != "" {
+		fmt.Println("Request Session:", historyApp.Request.Session)
+	}
+	if historyApp.Request.Environment != "" {
+		fmt.Println("Request Environment:", historyApp.Request.Environment)
+	}
+	if len(historyApp.Request.Extract) > 0 {
+		fmt.Println("Request Extract:", strings.Join(historyApp.Request.Extract, " "))
+	}
+	fmt.Println("Request Headers:")
+	printHeader(historyApp.Request.Header)
+	if template := historyApp.Request.Template; template != nil {
+		fmt.Println("Request Template URL:", template.URL)
+		if template.Header != nil {
+			fmt.Println("Request Template Headers:")
+			printHeader(template.Header)
+		}
+		if template.Body != "" {
+			fmt.Println("Request Template Body:")
+			fmt.Println(template.Body)
+		}
+	}
 
+	fmt.Println("Response Status:", historyApp.Response.Proto, historyApp.Response.Status)
+	fmt.Println("Response Status Code:", historyApp.Response.StatusCode)
 	fmt.Println("Response Content Type:", historyApp.Response.ContentType)
 	fmt.Println("Response Content Length:", historyApp.Response.ContentLength)
+	timings := historyApp.Response.Timings
+	fmt.Println("Response Timings:", "blocked", timings.Blocked, "dns", timings.DNS, "connect", timings.Connect,
+		"tls", timings.SSL, "send", timings.Send, "wait", timings.Wait, "receive", timings.Receive)
+	fmt.Println("Response Headers:")
+	printHeader(historyApp.Response.Header)
 
 	return nil
 }
 
 // Replay a request from history
-func (app *Application) RunHistoryReplay() error {
-	historyApp, err := app.loadAppFromHistory()
+func (app *Application) RunHistoryReplay(opts *OptionSet) error {
+	if len(opts.Args()) == 0 && hasHistoryFilters(opts) {
+		return app.runBatchReplay(opts)
+	}
+
+	historyApp, err := app.loadAppFromHistory(opts.Args())
 	if err != nil {
 		return err
 	}
 
-	app.Request = historyApp.Request
+	err = app.prepareReplay(&historyApp, opts)
+	if err != nil {
+		return err
+	}
 
 	err = app.SendRequest()
 	if err != nil {
@@ -60,21 +226,53 @@ func (app *Application) RunHistoryReplay() error {
 		return err
 	}
 
+	return app.checkResponseStatus()
+}
+
+// Save a request from history under a name, to run it later
+func (app *Application) RunHistoryName(opts *OptionSet) error {
+	args := opts.Args()
+	if len(args) < 2 {
+		return errors.New("Missing history record index or ID and name. Usage: gohttp history name [1 | ID] NAME")
+	}
+
+	historyApp, err := app.loadAppFromHistory(args)
+	if err != nil {
+		return err
+	}
+
+	// Keep the arguments of requests made from the command line, so running parses them again.
+	// Imported records, including HAR entries recorded before they had their own mode, keep the importer's.
+	var requestArgs []string
+	if (historyApp.Mode == "http" || historyApp.Mode == "run") && len(historyApp.Args) > 0 &&
+		historyApp.Args[0] != "run" && historyApp.Args[0] != "import" {
+		requestArgs = historyApp.Args
+	}
+
+	err = app.saveNamedRequest(args[1], requestArgs, historyApp.Request)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Saved request as: " + args[1])
 	return nil
 }
 
 // Save a response from history to output file
-func (app *Application) RunHistorySave() error {
-	historyApp, err := app.loadAppFromHistory()
+func (app *Application) RunHistorySave(opts *OptionSet) error {
+	historyApp, err := app.loadAppFromHistory(opts.Args())
 	if err != nil {
 		return err
 	}
 
-	if len(app.Args) < 4 {
+	if len(opts.Args()) < 2 {
 		return errors.New("Missing output file path argument.")
 	}
 
-	historyApp.OutputFilePath = filepath.Clean(app.Args[3])
+	if historyApp.Request.NoHistoryBody {
+		return errors.New("History record " + historyApp.Id + " was saved with --no-body, so it has no stored response body.")
+	}
+
+	historyApp.OutputFilePath = filepath.Clean(opts.Args()[1])
 
 	fmt.Println("Saving history record's response data to file: " + historyApp.OutputFilePath)
 	err = historyApp.saveToOutputFile()
@@ -86,24 +284,40 @@ func (app *Application) RunHistorySave() error {
 }
 
 // Show reverse chronological requests/responses
-func (app *Application) RunHistoryList() error {
-	skip, limit, find, caseFlag := app.getHistoryListOptions()
-	items, itemIndexes, numTotal, numSkipped, err := app.getHistoryRecords(skip, limit, find, caseFlag)
+func (app *Application) RunHistoryList(opts *OptionSet) error {
+	output := strings.ToLower(opts.Value("output"))
+	if output != "table" && output != "json" && output != "csv" {
+		return errors.New("Invalid list output '" + output + "'. Expected table, json or csv.")
+	}
+	columns, err := parseHistoryColumns(opts.Value("columns"))
 	if err != nil {
 		return err
 	}
 
+	query, err := getHistoryQuery(opts)
+	if err != nil {
+		return err
+	}
+	entries, numMatched, numTotal, err := app.history.Query(query)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printHistoryJson(entries, columns)
+	} else if output == "csv" {
+		return printHistoryCsv(entries, columns)
+	}
+
 	if numTotal == 0 {
 		fmt.Println("Nothing in history.")
 	} else {
-		if len(items) == 0 {
+		if len(entries) == 0 {
 			fmt.Println("No results matching criteria.")
 		} else {
-			fmt.Println("Displaying", numSkipped+1, "to", numSkipped+1+len(items), "of", numTotal, "-", "Use skip and limit flags to page.")
+			fmt.Println("Displaying", query.Skip+1, "to", query.Skip+len(entries), "of", numMatched, "-", "Use skip and limit flags to page.")
 			fmt.Println("")
-			for i, j := 0, len(items); i < j; i++ {
-				fmt.Println(strconv.Itoa(itemIndexes[i]) + ". " + items[i].Name())
-			}
+			return printHistoryTable(entries, columns)
 		}
 	}
 
@@ -114,134 +328,79 @@ func (app *Application) RunHistoryList() error {
 //	Private functions
 //
 
-func (app *Application) getHistoryListOptions() (int, int, string, bool) {
-	var err error
-
-	skipOptMap := map[string]bool{
-		"-s":     true,
-		"--skip": true,
-	}
-	skipOpt := app.getOption(skipOptMap, "")
-	skip := 0
-	if skipOpt != "" {
-		skip, err = strconv.Atoi(skipOpt)
-		if err != nil || skip < 0 {
-			skip = 0
-		}
+// Print headers in a stable order, one value per line
+func printHeader(header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	limitOptMap := map[string]bool{
-		"-l":      true,
-		"--limit": true,
-	}
-	limitOpt := app.getOption(limitOptMap, "")
-	limit := 10
-	if limitOpt != "" {
-		limit, err = strconv.Atoi(limitOpt)
-		if err != nil || limit < 1 {
-			limit = 10
+	for i, j := 0, len(keys); i < j; i++ {
+		values := header[keys[i]]
+		for k, l := 0, len(values); k < l; k++ {
+			fmt.Println("	" + keys[i] + ": " + values[k])
 		}
 	}
+}
 
-	findOptMap := map[string]bool{
-		"-f":     true,
-		"--find": true,
+// Build a history query from list filter options
+func getHistoryQuery(opts *OptionSet) (HistoryQuery, error) {
+	skip := opts.IntValue("skip")
+	if skip < 0 {
+		skip = 0
 	}
-	find := app.getOption(findOptMap, "")
 
-	caseFlagMap := map[string]bool{
-		"-i":            true,
-		"--insensitive": true,
+	limit := opts.IntValue("limit")
+	if limit < 1 {
+		limit = 10
 	}
-	caseFlag := app.flagIsActive(caseFlagMap)
 
-	return skip, limit, find, caseFlag
-}
-
-func (app *Application) getHistoryRecords(skip int, limit int, find string, caseInsensitive bool) ([]os.FileInfo, []int, int, int, error) {
-	itemIndex := 0
-	numTotal := 0
-	numSkipped := 0
-	items := make([]os.FileInfo, 0, limit)
-	itemIndexes := make([]int, 0, limit)
-
-	fileInfos, err := ioutil.ReadDir(app.HistoryPath)
-	if err != nil {
-		return items, itemIndexes, numSkipped, numTotal, err
-	}
-
-	numTotal = len(fileInfos)
-	for i, j := len(fileInfos)-1, 0; i >= j && (limit < 1 || len(items) < limit); i-- {
-		fileName := fileInfos[i].Name()
-		if len(fileName) > 0 && string(fileName[0]) != "." {
-			flagAndLowerExists := caseInsensitive && strings.Index(strings.ToLower(fileName), strings.ToLower(find)) > -1
-			if numSkipped >= skip && (find == "" || flagAndLowerExists || strings.Index(fileName, find) > -1) {
-				items = append(items, fileInfos[i])
-				label := itemIndex + 1
-				itemIndexes = append(itemIndexes, label)
-			} else {
-				numSkipped++
-			}
-			// Keep numbers consistent for history items, regardless if filtering
-			itemIndex++
-		}
+	query := HistoryQuery{
+		Skip:            skip,
+		Limit:           limit,
+		Find:            opts.Value("find"),
+		CaseInsensitive: opts.Flag("insensitive"),
 	}
-
-	return items, itemIndexes, numTotal, numSkipped, nil
+	err := addHistoryQueryFilters(&query, opts, time.Now())
+	return query, err
 }
 
-// Load an app object from history file
-func (app *Application) loadAppFromHistory() (Application, error) {
+// Load an app object from history by positional index or record ID
+func (app *Application) loadAppFromHistory(args []string) (Application, error) {
 	historyApp := Application{}
 
-	if len(app.Args) < 3 {
-		return historyApp, errors.New("Missing history record index.")
+	if len(args) < 1 {
+		return historyApp, errors.New("Missing history record index or ID.")
 	}
 
-	historyIndex, err := strconv.Atoi(app.Args[2])
+	entry, err := app.findHistoryEntry(args[0])
 	if err != nil {
 		return historyApp, err
 	}
-	app.HistoryRecordId = historyIndex
+	app.HistoryRecordId = entry.Index
 
-	skip := 0
-	if historyIndex > 1 {
-		skip = historyIndex - 1
-	}
-	limit := 1
-
-	items, itemIndexes, _, _, err := app.getHistoryRecords(skip, limit, "", true)
-	if err != nil {
-		return historyApp, err
-	} else if len(items) != 1 || len(itemIndexes) != 1 {
-		return historyApp, errors.New("No history records found.")
-	} else if historyIndex != itemIndexes[0] {
-		return historyApp, errors.New("Invalid history record index: " + app.Args[2])
-	}
-	fileName := items[0].Name()
-	fileSize := items[0].Size()
-
-	file, err := os.Open(path.Join(app.HistoryPath, fileName))
-	if err != nil {
-		return historyApp, errors.New("Error opening history file " + fileName + "\n" + err.Error())
-	}
-	defer file.Close()
-
-	fileData := make([]byte, fileSize)
-	numBytesRead, err := file.Read(fileData)
-	if err != nil {
-		return historyApp, errors.New("Error reading history file: " + err.Error())
-	}
+	return app.history.Load(entry)
+}
 
-	if numBytesRead < int(fileSize) {
-		return historyApp, errors.New("Error reading history file: Read " +
-			strconv.Itoa(numBytesRead) + " out of " + strconv.Itoa(int(fileSize)) + "bytes.")
+// Find the index entry for a positional index or record ID
+func (app *Application) findHistoryEntry(arg string) (HistoryEntry, error) {
+	query := HistoryQuery{Limit: 1}
+	if isRecordId(arg) {
+		query.Id = arg
+	} else {
+		historyIndex, err := strconv.Atoi(arg)
+		if err != nil || historyIndex < 1 {
+			return HistoryEntry{}, errors.New("Invalid history record index or ID: " + arg)
+		}
+		query.Skip = historyIndex - 1
 	}
 
-	err = json.Unmarshal(fileData, &historyApp)
+	entries, _, _, err := app.history.Query(query)
 	if err != nil {
-		return historyApp, errors.New("Error unmarshalling json: " + err.Error())
+		return HistoryEntry{}, err
+	} else if len(entries) != 1 {
+		return HistoryEntry{}, errors.New("No history record found for: " + arg)
 	}
-
-	return historyApp, nil
+	return entries[0], nil
 }
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Crockford's base32 alphabet, used for sortable record IDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Matches a record ID: 10 characters of millisecond timestamp followed by 16 random characters
+var recordIdRegexp = regexp.MustCompile("^[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26}$")
+
+//
+//	Private functions
+//
+
+// Create a ULID: a 48-bit millisecond timestamp and 80 random bits,
+// so IDs are unique and sort in creation order
+func newRecordId(t time.Time) (string, error) {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	_, err := rand.Read(data[6:])
+	if err != nil {
+		return "", err
+	}
+	return encodeRecordId(data), nil
+}
+
+// Derive a stable ID for a record saved before records had IDs,
+// from its start time and position in the records log
+func legacyRecordId(t time.Time, offset int64) string {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	sum := sha256.Sum256([]byte(t.UTC().Format(time.RFC3339Nano) + "@" + strconv.FormatInt(offset, 10)))
+	copy(data[6:], sum[:10])
+	return encodeRecordId(data)
+}
+
+// Encode 128 bits as Crockford base32
+func encodeRecordId(data [16]byte) string {
+	// 128 bits encode to 26 characters of 5 bits each, with 2 leading zero bits
+	high := binary.BigEndian.Uint64(data[:8])
+	low := binary.BigEndian.Uint64(data[8:])
+	id := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		id[i] = crockfordAlphabet[low&31]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(id)
+}
+
+// Determine if an argument is a record ID rather than a positional index
+func isRecordId(arg string) bool {
+	return recordIdRegexp.MatchString(arg)
+}
+
+// Normalize a record ID typed by a user
+func normalizeRecordId(id string) string {
+	return strings.ToUpper(id)
+}
@@ -0,0 +1,533 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Import formats
+var importFormats = []string{"curl", "har"}
+
+// Options that take a value, by short and long name
+var curlValueOptions = map[string]string{
+	"-X":                "request",
+	"--request":         "request",
+	"-H":                "header",
+	"--header":          "header",
+	"-d":                "data",
+	"--data":            "data",
+	"--data-ascii":      "data",
+	"--data-raw":        "data-raw",
+	"--data-binary":     "data-binary",
+	"--data-urlencode":  "data-urlencode",
+	"-u":                "user",
+	"--user":            "user",
+	"-b":                "cookie",
+	"--cookie":          "cookie",
+	"-A":                "user-agent",
+	"--user-agent":      "user-agent",
+	"-e":                "referer",
+	"--referer":         "referer",
+	"-m":                "max-time",
+	"--max-time":        "max-time",
+	"--connect-timeout": "ignored",
+	"--url":             "url",
+}
+
+// Options without a value; ignored ones only affect curl's own output
+var curlFlagOptions = map[string]string{
+	"-k":           "insecure",
+	"--insecure":   "insecure",
+	"--compressed": "compressed",
+	"-I":           "head",
+	"--head":       "head",
+	"-G":           "get",
+	"--get":        "get",
+	"--digest":     "digest",
+	"-L":           "ignored",
+	"--location":   "ignored",
+	"-s":           "ignored",
+	"--silent":     "ignored",
+	"-S":           "ignored",
+	"--show-error": "ignored",
+	"-v":           "ignored",
+	"--verbose":    "ignored",
+	"-i":           "ignored",
+	"--include":    "ignored",
+	"-f":           "ignored",
+	"--fail":       "ignored",
+	"-g":           "ignored",
+	"--globoff":    "ignored",
+	"--http1.1":    "ignored",
+	"--http2":      "ignored",
+}
+
+// Options for the import command
+func newImportOptionSet() *OptionSet {
+	opts := NewOptionSet("Import", "import curl ['curl ...' | -- curl ... | < file]\n	import har [file.har | < file]")
+	opts.String("", "save", "NAME", "", "Save a curl import as a named request instead of sending it")
+	opts.String("o", "output", "/path/to/output/file.json", "", "Save response body to file")
+	opts.Bool("p", "print", "Print response body")
+	opts.Bool("", "check-status", "Exit with an error code on 4xx and 5xx responses")
+	return opts
+}
+
+// Import a request from another tool, then send or save it
+func (app *Application) RunImport() error {
+	if len(app.Args) < 2 {
+		return errors.New("Missing import format. Expected one of: " + strings.Join(importFormats, ", ") + ".")
+	}
+	format := strings.ToLower(app.Args[1])
+
+	opts := newImportOptionSet()
+	err := opts.Parse(app.Args[2:])
+	if err != nil {
+		return err
+	}
+
+	if format == "har" {
+		if opts.Provided("save") {
+			return errors.New("The save flag is only valid for curl imports.")
+		}
+		return app.importHar(opts)
+	} else if format == "curl" {
+		err = app.importCurl(opts)
+	} else {
+		return errors.New("Invalid import format '" + format + "'. Expected one of: " + strings.Join(importFormats, ", ") + ".")
+	}
+	if err != nil {
+		return err
+	}
+
+	saveName := opts.Value("save")
+	if saveName != "" {
+		err = app.saveNamedRequest(saveName, nil, app.Request)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Saved request as: " + saveName)
+		return nil
+	}
+
+	app.OutputFilePath = opts.Value("output")
+	app.Request.PrintResponse = opts.Flag("print")
+	app.Request.CheckStatus = opts.Flag("check-status")
+
+	err = app.SendRequest()
+	if err != nil {
+		return err
+	}
+
+	err = app.SaveApp()
+	if err != nil {
+		return err
+	}
+
+	return app.checkResponseStatus()
+}
+
+//
+//	Private functions
+//
+
+// Create the app request from a curl command line given as one argument, as words, or on stdin
+func (app *Application) importCurl(opts *OptionSet) error {
+	fmt.Println("Parsing curl command...")
+
+	var err error
+	words := opts.Args()
+	if len(words) == 0 || (len(words) == 1 && words[0] == "-") {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.New("Error reading standard input: " + err.Error())
+		}
+		words, err = splitShellWords(string(input))
+		if err != nil {
+			return err
+		}
+	} else if len(words) == 1 {
+		words, err = splitShellWords(words[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	words = splitCurlShortFlags(words)
+	request, err := parseCurlCommand(words)
+	if err != nil {
+		return err
+	}
+	app.Request = request
+
+	// Keep the curl words in the arguments saved to history, with their secrets removed
+	app.Args = append(append(append([]string{}, app.Args[:2]...), opts.OptionArgs(app.Args[2:])...), "--")
+	app.Args = append(app.Args, redactCurlWords(words, app.redactor)...)
+	return nil
+}
+
+// Split combined short flags into separate words, as in -sSL, where the last may
+// take a value, as in -sXPOST. Values of options are left as they are.
+func splitCurlShortFlags(words []string) []string {
+	split := make([]string, 0, len(words))
+	for i, j := 0, len(words); i < j; i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "-") || strings.HasPrefix(word, "--") || len(word) <= 2 {
+			split = append(split, word)
+			if _, isValueOption := curlValueOptions[word]; isValueOption && i+1 < j {
+				i++
+				split = append(split, words[i])
+			}
+			continue
+		}
+
+		for k, l := 1, len(word); k < l; k++ {
+			name := "-" + word[k:k+1]
+			if _, isFlagOption := curlFlagOptions[name]; isFlagOption {
+				split = append(split, name)
+				continue
+			}
+			// A value option takes the rest of the word, or the next word, as its value.
+			// Unknown options are kept whole so they are reported as given.
+			if _, isValueOption := curlValueOptions[name]; isValueOption && k == l-1 && i+1 < j {
+				split = append(split, name, words[i+1])
+				i++
+			} else {
+				split = append(split, "-"+word[k:])
+			}
+			break
+		}
+	}
+	return split
+}
+
+// Split a curl option word into its name and any attached value, as in --header=X or -XPOST
+func splitCurlOption(word string) (string, string, bool) {
+	if strings.HasPrefix(word, "--") {
+		if eq := strings.Index(word, "="); eq > -1 {
+			return word[:eq], word[eq+1:], true
+		}
+	} else if strings.HasPrefix(word, "-") && len(word) > 2 {
+		if _, present := curlValueOptions[word[:2]]; present {
+			return word[:2], word[2:], true
+		}
+	}
+	return word, "", false
+}
+
+// Copy of curl command words with passwords, cookies and sensitive header values redacted
+func redactCurlWords(words []string, redactor *historyRedactor) []string {
+	redacted := append([]string{}, words...)
+	for i, j := 0, len(redacted); i < j; i++ {
+		name, value, hasValue := splitCurlOption(redacted[i])
+		option, isValueOption := curlValueOptions[name]
+		if !strings.HasPrefix(redacted[i], "-") || !isValueOption {
+			continue
+		}
+		// A separate value is the next word
+		if !hasValue {
+			if i+1 >= j {
+				break
+			}
+			i++
+			value = redacted[i]
+		}
+
+		if option == "user" {
+			if colon := strings.Index(value, ":"); colon > -1 {
+				value = value[:colon+1] + redactedValue
+			}
+		} else if option == "cookie" {
+			value = redactedValue
+		} else if option == "header" {
+			value = redactor.redactHeaderOption(value)
+		} else {
+			continue
+		}
+
+		if !hasValue {
+			redacted[i] = value
+		} else if strings.HasPrefix(name, "--") {
+			redacted[i] = name + "=" + value
+		} else {
+			redacted[i] = name + value
+		}
+	}
+	return redacted
+}
+
+// Build a request from the words of a curl command line
+func parseCurlCommand(words []string) (Request, error) {
+	request := Request{Header: http.Header{}, Timeout: 60, Accept: "*/*"}
+	if len(words) > 0 && (words[0] == "curl" || strings.HasSuffix(words[0], "/curl")) {
+		words = words[1:]
+	}
+
+	method := ""
+	rawUrl := ""
+	data := make([]string, 0)
+	user := ""
+	digest := false
+	compressed := false
+	getWithData := false
+
+	for i, j := 0, len(words); i < j; i++ {
+		word := words[i]
+		name, value, hasValue := splitCurlOption(word)
+		option, isValueOption := curlValueOptions[name]
+		flag, isFlagOption := curlFlagOptions[name]
+		if !strings.HasPrefix(word, "-") || word == "-" {
+			rawUrl = word
+			continue
+		} else if !isValueOption && !isFlagOption {
+			return request, errors.New("Unsupported curl option: " + name)
+		} else if isFlagOption {
+			if flag == "insecure" {
+				request.Insecure = true
+			} else if flag == "compressed" {
+				compressed = true
+			} else if flag == "head" {
+				method = "HEAD"
+			} else if flag == "get" {
+				getWithData = true
+			} else if flag == "digest" {
+				digest = true
+			}
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= j {
+				return request, errors.New("Missing value for curl option " + name + ".")
+			}
+			i++
+			value = words[i]
+		}
+
+		if option == "request" {
+			method = strings.ToUpper(value)
+		} else if option == "header" {
+			parts := strings.SplitN(value, ":", 2)
+			key := strings.TrimSpace(parts[0])
+			if len(parts) < 2 || key == "" {
+				return request, errors.New("Invalid curl header '" + value + "'.")
+			}
+			request.Header.Add(key, strings.TrimSpace(parts[1]))
+		} else if option == "data" || option == "data-binary" {
+			if strings.HasPrefix(value, "@") {
+				fileData, err := ioutil.ReadFile(value[1:])
+				if err != nil {
+					return request, errors.New("Error reading curl data file " + value[1:] + ": " + err.Error())
+				}
+				value = string(fileData)
+				if option == "data" {
+					value = strings.Replace(strings.Replace(value, "\r", "", -1), "\n", "", -1)
+				}
+			}
+			data = append(data, value)
+		} else if option == "data-raw" {
+			data = append(data, value)
+		} else if option == "data-urlencode" {
+			if eq := strings.Index(value, "="); eq > -1 {
+				data = append(data, value[:eq+1]+url.QueryEscape(value[eq+1:]))
+			} else {
+				data = append(data, url.QueryEscape(value))
+			}
+		} else if option == "user" {
+			user = value
+		} else if option == "cookie" {
+			if !strings.Contains(value, "=") {
+				return request, errors.New("Curl cookie files are not supported: " + value)
+			}
+			request.Header.Add("Cookie", value)
+		} else if option == "user-agent" {
+			request.Header.Set("User-Agent", value)
+		} else if option == "referer" {
+			request.Header.Set("Referer", value)
+		} else if option == "max-time" {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return request, errors.New("Invalid curl --max-time value: " + value)
+			}
+			request.Timeout = int(seconds + 0.999)
+		} else if option == "url" {
+			rawUrl = value
+		}
+	}
+
+	if rawUrl == "" {
+		return request, errors.New("Missing URL in curl command.")
+	}
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "http://" + rawUrl
+	}
+	requestUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return request, errors.New("Error parsing URL: " + err.Error())
+	}
+
+	body := strings.Join(data, "&")
+	if getWithData && body != "" {
+		if requestUrl.RawQuery != "" {
+			requestUrl.RawQuery += "&"
+		}
+		requestUrl.RawQuery += body
+		body = ""
+	}
+
+	if method == "" {
+		method = "GET"
+		if body != "" {
+			method = "POST"
+		}
+	}
+
+	if user != "" {
+		authType := "basic"
+		if digest {
+			authType = "digest"
+		}
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) < 2 {
+			parts = append(parts, "")
+		}
+		request.Auth = &Auth{Type: authType, Username: parts[0], Password: parts[1]}
+	}
+
+	// Let the transport negotiate and decode compression, as curl does with --compressed
+	if compressed {
+		request.Header.Del("Accept-Encoding")
+	}
+
+	request.ContentType = request.Header.Get("Content-Type")
+	request.Header.Del("Content-Type")
+	if request.ContentType == "" && body != "" {
+		request.ContentType = "application/x-www-form-urlencoded"
+	}
+	if accept := request.Header.Get("Accept"); accept != "" {
+		request.Accept = accept
+		request.Header.Del("Accept")
+	}
+
+	request.Method = method
+	request.URL = requestUrl
+	request.Body = []byte(body)
+	request.ContentLength = len(request.Body)
+	return request, nil
+}
+
+// Split a shell command line into words, following POSIX quoting rules and bash $'...' strings
+func splitShellWords(input string) ([]string, error) {
+	words := make([]string, 0)
+	word := make([]byte, 0)
+	inWord := false
+
+	for i, j := 0, len(input); i < j; i++ {
+		c := input[i]
+
+		if c == '\\' && i+1 < j {
+			i++
+			if input[i] != '\n' {
+				word = append(word, input[i])
+				inWord = true
+			}
+		} else if c == '\'' {
+			end := strings.IndexByte(input[i+1:], '\'')
+			if end < 0 {
+				return words, errors.New("Unterminated single quote in command.")
+			}
+			word = append(word, input[i+1:i+1+end]...)
+			i += end + 1
+			inWord = true
+		} else if c == '$' && i+1 < j && input[i+1] == '\'' {
+			i += 2
+			for ; i < j && input[i] != '\''; i++ {
+				if input[i] == '\\' && i+1 < j {
+					i++
+					decoded, consumed := decodeAnsiEscape(input[i:])
+					word = append(word, decoded...)
+					i += consumed - 1
+				} else {
+					word = append(word, input[i])
+				}
+			}
+			if i >= j {
+				return words, errors.New("Unterminated $' quote in command.")
+			}
+			inWord = true
+		} else if c == '"' {
+			i++
+			for ; i < j && input[i] != '"'; i++ {
+				if input[i] == '\\' && i+1 < j && strings.IndexByte("\"\\$`\n", input[i+1]) > -1 {
+					i++
+					if input[i] == '\n' {
+						continue
+					}
+				}
+				word = append(word, input[i])
+			}
+			if i >= j {
+				return words, errors.New("Unterminated double quote in command.")
+			}
+			inWord = true
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			if inWord {
+				words = append(words, string(word))
+				word = make([]byte, 0)
+				inWord = false
+			}
+		} else {
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// Decode one backslash escape inside $'...', returning bytes and input consumed
+func decodeAnsiEscape(s string) ([]byte, int) {
+	simple := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v', 'e': 0x1b, '\\': '\\', '\'': '\'', '"': '"', '?': '?'}
+	if decoded, present := simple[s[0]]; present {
+		return []byte{decoded}, 1
+	}
+
+	if s[0] == 'x' {
+		end := 1
+		for end < len(s) && end < 3 && strings.IndexByte("0123456789abcdefABCDEF", s[end]) > -1 {
+			end++
+		}
+		if value, err := strconv.ParseUint(s[1:end], 16, 8); err == nil {
+			return []byte{byte(value)}, end
+		}
+	} else if s[0] >= '0' && s[0] <= '7' {
+		end := 1
+		for end < len(s) && end < 3 && s[end] >= '0' && s[end] <= '7' {
+			end++
+		}
+		if value, err := strconv.ParseUint(s[:end], 8, 8); err == nil {
+			return []byte{byte(value)}, end
+		}
+	} else if s[0] == 'u' || s[0] == 'U' {
+		size := 5
+		if s[0] == 'U' {
+			size = 9
+		}
+		end := 1
+		for end < len(s) && end < size && strings.IndexByte("0123456789abcdefABCDEF", s[end]) > -1 {
+			end++
+		}
+		if value, err := strconv.ParseUint(s[1:end], 16, 32); err == nil {
+			return []byte(string(rune(value))), end
+		}
+	}
+	return []byte{'\\', s[0]}, 1
+}
@@ -0,0 +1,121 @@
+package application
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		err      string
+	}{
+		{input: "curl https://a", expected: []string{"curl", "https://a"}},
+		{input: "  curl \t -s\n https://a  ", expected: []string{"curl", "-s", "https://a"}},
+		{input: `curl -H 'X-A: it'\''s'`, expected: []string{"curl", "-H", "X-A: it's"}},
+		{input: `curl -d "{\"a\": \"\$1\"}"`, expected: []string{"curl", "-d", `{"a": "$1"}`}},
+		{input: `curl -d "a\b"`, expected: []string{"curl", "-d", `a\b`}},
+		{input: "curl \\\n  https://a", expected: []string{"curl", "https://a"}},
+		{input: `curl a\ b`, expected: []string{"curl", "a b"}},
+		{input: `curl $'a\nb\x41\''`, expected: []string{"curl", "a\nbA'"}},
+		{input: `curl ''`, expected: []string{"curl", ""}},
+		{input: `curl 'a`, err: "Unterminated single quote"},
+		{input: `curl "a`, err: "Unterminated double quote"},
+		{input: `curl $'a`, err: "Unterminated $' quote"},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		words, err := splitShellWords(tests[i].input)
+		if tests[i].err != "" {
+			if err == nil || !strings.Contains(err.Error(), tests[i].err) {
+				t.Errorf("%q: expected error containing %q, got %v", tests[i].input, tests[i].err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tests[i].input, err)
+		} else if !reflect.DeepEqual(words, tests[i].expected) {
+			t.Errorf("%q: got %q, expected %q", tests[i].input, words, tests[i].expected)
+		}
+	}
+}
+
+func TestParseCurlCommand(t *testing.T) {
+	tests := []struct {
+		command     string
+		method      string
+		url         string
+		header      map[string]string
+		contentType string
+		body        string
+		auth        *Auth
+		timeout     int
+		insecure    bool
+		err         string
+	}{
+		{command: "curl https://a.example/x", method: "GET", url: "https://a.example/x", timeout: 60},
+		{command: "curl a.example", method: "GET", url: "http://a.example", timeout: 60},
+		{command: "curl -X put https://a/x -d 'a=1' -d b=2", method: "PUT", url: "https://a/x",
+			contentType: "application/x-www-form-urlencoded", body: "a=1&b=2", timeout: 60},
+		{command: `curl https://a -H 'Content-Type: application/json' --data-raw '{"a":1}'`, method: "POST", url: "https://a",
+			contentType: "application/json", body: `{"a":1}`, timeout: 60},
+		{command: "curl -G https://a/s -d q=1 --data-urlencode 'w=a b'", method: "GET", url: "https://a/s?q=1&w=a+b", timeout: 60},
+		{command: "curl -sSLk -XPOST https://a -m 2.5", method: "POST", url: "https://a", timeout: 3, insecure: true},
+		{command: "curl -u user:pass --digest https://a", method: "GET", url: "https://a", timeout: 60,
+			auth: &Auth{Type: "digest", Username: "user", Password: "pass"}},
+		{command: "curl -H 'X-A: 1' -b 'sid=2' -A agent https://a", method: "GET", url: "https://a", timeout: 60,
+			header: map[string]string{"X-A": "1", "Cookie": "sid=2", "User-Agent": "agent"}},
+		{command: "curl -I https://a", method: "HEAD", url: "https://a", timeout: 60},
+		{command: "curl --unknown https://a", err: "Unsupported curl option: --unknown"},
+		{command: "curl -H", err: "Missing value for curl option -H."},
+		{command: "curl -s", err: "Missing URL"},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		test := tests[i]
+		words, err := splitShellWords(test.command)
+		if err != nil {
+			t.Fatalf("%q: %v", test.command, err)
+		}
+		request, err := parseCurlCommand(splitCurlShortFlags(words))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error containing %q, got %v", test.command, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.command, err)
+			continue
+		}
+
+		if request.Method != test.method {
+			t.Errorf("%q: method = %q, expected %q", test.command, request.Method, test.method)
+		}
+		if request.URL.String() != test.url {
+			t.Errorf("%q: url = %q, expected %q", test.command, request.URL.String(), test.url)
+		}
+		for name, value := range test.header {
+			if request.Header.Get(name) != value {
+				t.Errorf("%q: header %s = %q, expected %q", test.command, name, request.Header.Get(name), value)
+			}
+		}
+		if request.ContentType != test.contentType {
+			t.Errorf("%q: content type = %q, expected %q", test.command, request.ContentType, test.contentType)
+		}
+		if string(request.Body) != test.body || request.ContentLength != len(test.body) {
+			t.Errorf("%q: body = %q (%d bytes), expected %q", test.command, request.Body, request.ContentLength, test.body)
+		}
+		if !reflect.DeepEqual(request.Auth, test.auth) {
+			t.Errorf("%q: auth = %+v, expected %+v", test.command, request.Auth, test.auth)
+		}
+		if request.Timeout != test.timeout {
+			t.Errorf("%q: timeout = %d, expected %d", test.command, request.Timeout, test.timeout)
+		}
+		if request.Insecure != test.insecure {
+			t.Errorf("%q: insecure = %v, expected %v", test.command, request.Insecure, test.insecure)
+		}
+	}
+}
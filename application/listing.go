@@ -0,0 +1,182 @@
+package application
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Columns available for history listings
+var historyColumns = []string{"index", "id", "time", "method", "status", "duration", "size", "url", "host"}
+
+// Columns shown when --columns is not given
+const defaultHistoryColumns = "index,id,time,method,status,duration,size,url"
+
+//
+//	Private functions
+//
+
+// Parse a comma separated list of history columns
+func parseHistoryColumns(value string) ([]string, error) {
+	columns := make([]string, 0)
+	parts := strings.Split(value, ",")
+	for i, j := 0, len(parts); i < j; i++ {
+		column := strings.ToLower(strings.TrimSpace(parts[i]))
+		if column == "" {
+			continue
+		}
+
+		known := false
+		for k, l := 0, len(historyColumns); k < l; k++ {
+			if column == historyColumns[k] {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return columns, errors.New("Unknown history column '" + column + "'. Expected one of: " + strings.Join(historyColumns, ", ") + ".")
+		}
+		columns = append(columns, column)
+	}
+
+	if len(columns) == 0 {
+		return columns, errors.New("No history columns selected.")
+	}
+	return columns, nil
+}
+
+// Format a column of an index entry for display in a table
+func historyTableValue(entry HistoryEntry, column string) string {
+	if column == "index" {
+		return strconv.Itoa(entry.Index)
+	} else if column == "time" {
+		return entry.StartTime.Local().Format("2006-01-02 15:04:05")
+	} else if column == "duration" {
+		return roundDuration(entry.Duration).String()
+	} else if column == "size" {
+		return formatSize(entry.Size)
+	}
+	return historyDataValue(entry, column)
+}
+
+// Format a column of an index entry for machine readable output
+func historyDataValue(entry HistoryEntry, column string) string {
+	if column == "index" {
+		return strconv.Itoa(entry.Index)
+	} else if column == "id" {
+		return entry.Id
+	} else if column == "time" {
+		return entry.StartTime.Format(time.RFC3339Nano)
+	} else if column == "method" {
+		return entry.Method
+	} else if column == "status" {
+		return strconv.Itoa(entry.Status)
+	} else if column == "duration" {
+		return strconv.FormatFloat(milliseconds(entry.Duration), 'f', -1, 64)
+	} else if column == "size" {
+		return strconv.Itoa(entry.Size)
+	} else if column == "url" {
+		return entry.URL
+	} else if column == "host" {
+		return entry.Host
+	}
+	return ""
+}
+
+// Print entries as an aligned table with a header row
+func printHistoryTable(entries []HistoryEntry, columns []string) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	header := make([]string, len(columns))
+	for i, j := 0, len(columns); i < j; i++ {
+		header[i] = strings.ToUpper(columns[i])
+	}
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+
+	for i, j := 0, len(entries); i < j; i++ {
+		row := make([]string, len(columns))
+		for k, l := 0, len(columns); k < l; k++ {
+			row[k] = historyTableValue(entries[i], columns[k])
+		}
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+// Print entries as a json array of objects keyed by column
+func printHistoryJson(entries []HistoryEntry, columns []string) error {
+	rows := make([]map[string]interface{}, 0, len(entries))
+	for i, j := 0, len(entries); i < j; i++ {
+		row := make(map[string]interface{}, len(columns))
+		for k, l := 0, len(columns); k < l; k++ {
+			column := columns[k]
+			if column == "index" {
+				row[column] = entries[i].Index
+			} else if column == "status" {
+				row[column] = entries[i].Status
+			} else if column == "size" {
+				row[column] = entries[i].Size
+			} else if column == "duration" {
+				row[column] = milliseconds(entries[i].Duration)
+			} else {
+				row[column] = historyDataValue(entries[i], column)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	jsonBytes, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return errors.New("Error creating history json: " + err.Error())
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// Print entries as csv with a header row
+func printHistoryCsv(entries []HistoryEntry, columns []string) error {
+	writer := csv.NewWriter(os.Stdout)
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	for i, j := 0, len(entries); i < j; i++ {
+		row := make([]string, len(columns))
+		for k, l := 0, len(columns); k < l; k++ {
+			row[k] = historyDataValue(entries[i], columns[k])
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Round a duration for display
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Millisecond)
+	} else if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// Format a byte count for display
+func formatSize(size int) string {
+	if size < 1024 {
+		return strconv.Itoa(size) + " B"
+	} else if size < 1024*1024 {
+		return strconv.FormatFloat(float64(size)/1024, 'f', 1, 64) + " KB"
+	}
+	return strconv.FormatFloat(float64(size)/(1024*1024), 'f', 1, 64) + " MB"
+}
@@ -0,0 +1,326 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of values an option can hold
+const (
+	BoolOption = iota
+	StringOption
+	IntOption
+	ListOption
+)
+
+// Definition of a single command line option
+type Option struct {
+	Short       string
+	Long        string
+	Kind        int
+	ValueName   string
+	Default     string
+	Description string
+}
+
+// Typed options and positional arguments for a single command
+type OptionSet struct {
+	Title      string
+	Usage      string
+	Options    []*Option
+	values     map[string][]string
+	positions  map[string][]argPosition
+	args       []string
+	argIndexes map[int]bool
+}
+
+// Location of an option value within the parsed arguments
+type argPosition struct {
+	index  int
+	offset int
+}
+
+// Create an empty option set for a command
+func NewOptionSet(title string, usage string) *OptionSet {
+	return &OptionSet{
+		Title:      title,
+		Usage:      usage,
+		Options:    make([]*Option, 0),
+		values:     make(map[string][]string),
+		positions:  make(map[string][]argPosition),
+		args:       make([]string, 0),
+		argIndexes: make(map[int]bool),
+	}
+}
+
+// Define a flag that takes no value
+func (set *OptionSet) Bool(short string, long string, description string) {
+	set.add(&Option{Short: short, Long: long, Kind: BoolOption, Description: description})
+}
+
+// Define an option that takes a single string value
+func (set *OptionSet) String(short string, long string, valueName string, defaultValue string, description string) {
+	set.add(&Option{Short: short, Long: long, Kind: StringOption, ValueName: valueName, Default: defaultValue, Description: description})
+}
+
+// Define an option that takes a single integer value
+func (set *OptionSet) Int(short string, long string, valueName string, defaultValue int, description string) {
+	set.add(&Option{Short: short, Long: long, Kind: IntOption, ValueName: valueName, Default: strconv.Itoa(defaultValue), Description: description})
+}
+
+// Define an option that may be given more than once
+func (set *OptionSet) List(short string, long string, valueName string, description string) {
+	set.add(&Option{Short: short, Long: long, Kind: ListOption, ValueName: valueName, Description: description})
+}
+
+// Parse command line arguments into option values and positional arguments
+func (set *OptionSet) Parse(args []string) error {
+	for i, j := 0, len(args); i < j; i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			set.args = append(set.args, args[i+1:]...)
+			for k := i; k < j; k++ {
+				set.argIndexes[k] = true
+			}
+			break
+		} else if strings.HasPrefix(arg, "--") {
+			name := arg[2:]
+			value := ""
+			hasValue := false
+			if eq := strings.Index(name, "="); eq > -1 {
+				name, value, hasValue = name[:eq], name[eq+1:], true
+			}
+
+			opt := set.lookup(name, false)
+			if opt == nil {
+				return unknownOptionError("--" + name)
+			}
+
+			position := argPosition{index: i, offset: len(arg) - len(value)}
+			if opt.Kind == BoolOption {
+				if !hasValue {
+					value = "true"
+				}
+			} else if !hasValue {
+				if i+1 >= j {
+					return errors.New("Missing value for --" + opt.Long + ".")
+				}
+				i++
+				value = args[i]
+				position = argPosition{index: i, offset: 0}
+			}
+
+			err := set.setValue(opt, value, position)
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			// Short flags may be combined, as in -pj, and the last may take a value, as in -t5
+			shorts := arg[1:]
+			for k, l := 0, len(shorts); k < l; k++ {
+				opt := set.lookup(shorts[k:k+1], true)
+				if opt == nil {
+					return unknownOptionError("-" + shorts[k:k+1])
+				}
+
+				if opt.Kind == BoolOption {
+					err := set.setValue(opt, "true", argPosition{index: i, offset: len(arg)})
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				value := shorts[k+1:]
+				position := argPosition{index: i, offset: k + 2}
+				if value == "" {
+					if i+1 >= j {
+						return errors.New("Missing value for -" + opt.Short + ".")
+					}
+					i++
+					value = args[i]
+					position = argPosition{index: i, offset: 0}
+				}
+
+				err := set.setValue(opt, value, position)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		} else {
+			set.args = append(set.args, arg)
+			set.argIndexes[i] = true
+		}
+	}
+
+	return nil
+}
+
+// Positional arguments left after parsing options
+func (set *OptionSet) Args() []string {
+	return set.args
+}
+
+// Copy of parsed arguments with the positional arguments and any -- removed, leaving the options
+func (set *OptionSet) OptionArgs(args []string) []string {
+	optionArgs := make([]string, 0, len(args))
+	for i, j := 0, len(args); i < j; i++ {
+		if !set.argIndexes[i] {
+			optionArgs = append(optionArgs, args[i])
+		}
+	}
+	return optionArgs
+}
+
+// Determine if an option was given on the command line
+func (set *OptionSet) Provided(long string) bool {
+	_, present := set.values[long]
+	return present
+}
+
+// Get value of a bool flag
+func (set *OptionSet) Flag(long string) bool {
+	values := set.values[long]
+	if len(values) == 0 {
+		return false
+	}
+	flag, _ := strconv.ParseBool(values[len(values)-1])
+	return flag
+}
+
+// Get value of a string option, or its default; the last occurrence wins
+func (set *OptionSet) Value(long string) string {
+	values := set.values[long]
+	if len(values) == 0 {
+		opt := set.lookup(long, false)
+		if opt == nil {
+			return ""
+		}
+		return opt.Default
+	}
+	return values[len(values)-1]
+}
+
+// Get value of an integer option, or its default
+func (set *OptionSet) IntValue(long string) int {
+	value, _ := strconv.Atoi(set.Value(long))
+	return value
+}
+
+// Get all values of a repeatable option, in order
+func (set *OptionSet) Values(long string) []string {
+	values := make([]string, len(set.values[long]))
+	copy(values, set.values[long])
+	return values
+}
+
+// Copy of parsed arguments with the values of the given options replaced
+func (set *OptionSet) RedactArgs(args []string, replacement string, longs ...string) []string {
+	redacted := args
+	for i, j := 0, len(longs); i < j; i++ {
+		redacted = set.MapArgs(redacted, longs[i], func(value string) string {
+			return replacement
+		})
+	}
+	return redacted
+}
+
+// Copy of parsed arguments with each value of an option passed through mapping,
+// whether it was given as --long VALUE, --long=VALUE, -sVALUE or -s VALUE
+func (set *OptionSet) MapArgs(args []string, long string, mapping func(value string) string) []string {
+	mapped := make([]string, len(args))
+	copy(mapped, args)
+
+	positions := set.positions[long]
+	for i, j := 0, len(positions); i < j; i++ {
+		position := positions[i]
+		if position.index < len(mapped) && position.offset <= len(mapped[position.index]) {
+			arg := mapped[position.index]
+			mapped[position.index] = arg[:position.offset] + mapping(arg[position.offset:])
+		}
+	}
+	return mapped
+}
+
+// Print option descriptions for help text
+func (set *OptionSet) PrintOptions() {
+	usages := make([]string, len(set.Options))
+	width := 0
+	for i, j := 0, len(set.Options); i < j; i++ {
+		opt := set.Options[i]
+
+		names := "--" + opt.Long
+		if opt.Short != "" {
+			names = "-" + opt.Short + " | " + names
+		}
+		usages[i] = "(" + names + ")"
+		if opt.ValueName != "" {
+			usages[i] += " " + opt.ValueName
+		}
+
+		if len(usages[i]) > width {
+			width = len(usages[i])
+		}
+	}
+
+	for i, j := 0, len(set.Options); i < j; i++ {
+		opt := set.Options[i]
+
+		description := opt.Description
+		if opt.Kind == ListOption {
+			description += " (repeatable)"
+		} else if opt.Default != "" {
+			description += " (default " + opt.Default + ")"
+		}
+
+		fmt.Printf("	%-*s  %s\n", width, usages[i], description)
+	}
+}
+
+//
+//	Private functions
+//
+
+func (set *OptionSet) add(opt *Option) {
+	set.Options = append(set.Options, opt)
+}
+
+// Find an option by short or long name
+func (set *OptionSet) lookup(name string, short bool) *Option {
+	for i, j := 0, len(set.Options); i < j; i++ {
+		opt := set.Options[i]
+		if (short && opt.Short != "" && opt.Short == name) || (!short && opt.Long == name) {
+			return opt
+		}
+	}
+	return nil
+}
+
+// Validate and record a value for an option
+func (set *OptionSet) setValue(opt *Option, value string, position argPosition) error {
+	if opt.Kind == BoolOption {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("Invalid value '" + value + "' for --" + opt.Long + ". Expected true or false.")
+		}
+	} else if opt.Kind == IntOption {
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("Invalid value '" + value + "' for --" + opt.Long + ". Expected an integer.")
+		}
+	}
+
+	if opt.Kind == ListOption {
+		set.values[opt.Long] = append(set.values[opt.Long], value)
+	} else {
+		set.values[opt.Long] = []string{value}
+	}
+	set.positions[opt.Long] = append(set.positions[opt.Long], position)
+	return nil
+}
+
+func unknownOptionError(name string) error {
+	return errors.New("Unknown flag '" + name + "'. Try 'gohttp help' for usage details.")
+}
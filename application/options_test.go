@@ -0,0 +1,130 @@
+package application
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestOptionSet() *OptionSet {
+	opts := NewOptionSet("Test", "test FLAGS")
+	opts.Bool("p", "print", "Print response body")
+	opts.Bool("j", "json", "Send request body as application/json")
+	opts.Int("t", "timeout", "SECONDS", 60, "Response header timeout")
+	opts.String("u", "auth", "USER:PASS", "", "Authenticate with username and password")
+	opts.String("", "bearer", "TOKEN", "", "Authenticate with a bearer token")
+	opts.List("H", "header", "'X-Api-Key: value'", "Custom request header")
+	return opts
+}
+
+func TestOptionSetParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		print   bool
+		json    bool
+		timeout int
+		auth    string
+		headers []string
+		rest    []string
+		err     string
+	}{
+		{name: "defaults", args: []string{"get", "http://a"}, timeout: 60, headers: []string{}, rest: []string{"get", "http://a"}},
+		{name: "long forms", args: []string{"--print", "--timeout", "5", "--auth=u:p", "url"}, print: true, timeout: 5, auth: "u:p", headers: []string{}, rest: []string{"url"}},
+		{name: "combined shorts", args: []string{"-pj", "-t7", "url"}, print: true, json: true, timeout: 7, headers: []string{}, rest: []string{"url"}},
+		{name: "short with separate value", args: []string{"-u", "u:p", "url"}, timeout: 60, auth: "u:p", headers: []string{}, rest: []string{"url"}},
+		{name: "repeated list", args: []string{"-H", "A: 1", "--header=B: 2", "-HC: 3"}, timeout: 60, headers: []string{"A: 1", "B: 2", "C: 3"}, rest: []string{}},
+		{name: "last value wins", args: []string{"-t", "1", "--timeout=2"}, timeout: 2, headers: []string{}, rest: []string{}},
+		{name: "bool with value", args: []string{"--print=false", "url"}, timeout: 60, headers: []string{}, rest: []string{"url"}},
+		{name: "double dash", args: []string{"-p", "--", "-t", "5"}, print: true, timeout: 60, headers: []string{}, rest: []string{"-t", "5"}},
+		{name: "unknown long", args: []string{"--nope"}, err: "--nope"},
+		{name: "unknown short", args: []string{"-z"}, err: "-z"},
+		{name: "missing value", args: []string{"--auth"}, err: "Missing value for --auth."},
+		{name: "missing short value", args: []string{"-pt"}, err: "Missing value for -t."},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		test := tests[i]
+		opts := newTestOptionSet()
+		err := opts.Parse(test.args)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if opts.Flag("print") != test.print {
+			t.Errorf("%s: print = %v, expected %v", test.name, opts.Flag("print"), test.print)
+		}
+		if opts.Flag("json") != test.json {
+			t.Errorf("%s: json = %v, expected %v", test.name, opts.Flag("json"), test.json)
+		}
+		if opts.IntValue("timeout") != test.timeout {
+			t.Errorf("%s: timeout = %d, expected %d", test.name, opts.IntValue("timeout"), test.timeout)
+		}
+		if opts.Value("auth") != test.auth {
+			t.Errorf("%s: auth = %q, expected %q", test.name, opts.Value("auth"), test.auth)
+		}
+		if !reflect.DeepEqual(opts.Values("header"), test.headers) {
+			t.Errorf("%s: headers = %q, expected %q", test.name, opts.Values("header"), test.headers)
+		}
+		if !reflect.DeepEqual(opts.Args(), test.rest) {
+			t.Errorf("%s: args = %q, expected %q", test.name, opts.Args(), test.rest)
+		}
+	}
+}
+
+func TestOptionSetOptionArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"get", "url", "-p"}, []string{"-p"}},
+		{[]string{"-t", "5", "url", "--auth=u:p"}, []string{"-t", "5", "--auth=u:p"}},
+		{[]string{"-p", "--", "curl", "-H", "X: 1"}, []string{"-p"}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		opts := newTestOptionSet()
+		err := opts.Parse(tests[i].args)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tests[i].args, err)
+		}
+		optionArgs := opts.OptionArgs(tests[i].args)
+		if !reflect.DeepEqual(optionArgs, tests[i].expected) {
+			t.Errorf("%q: option args = %q, expected %q", tests[i].args, optionArgs, tests[i].expected)
+		}
+	}
+}
+
+func TestOptionSetRedactArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"url", "--auth", "u:p"}, []string{"url", "--auth", "X"}},
+		{[]string{"url", "--auth=u:p"}, []string{"url", "--auth=X"}},
+		{[]string{"url", "-uu:p"}, []string{"url", "-uX"}},
+		{[]string{"url", "-pu", "u:p"}, []string{"url", "-pu", "X"}},
+		{[]string{"url", "--bearer", "t", "-u", "u:p"}, []string{"url", "--bearer", "X", "-u", "X"}},
+		{[]string{"url", "-H", "A: 1"}, []string{"url", "-H", "A: 1"}},
+		{[]string{"--", "--auth", "u:p"}, []string{"--", "--auth", "u:p"}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		opts := newTestOptionSet()
+		err := opts.Parse(tests[i].args)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tests[i].args, err)
+		}
+		redacted := opts.RedactArgs(tests[i].args, "X", "auth", "bearer")
+		if !reflect.DeepEqual(redacted, tests[i].expected) {
+			t.Errorf("%q: redacted = %q, expected %q", tests[i].args, redacted, tests[i].expected)
+		}
+	}
+}
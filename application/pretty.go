@@ -0,0 +1,125 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//
+//	Private functions
+//
+
+// Print a body, formatted for its content type unless raw is set
+func printBody(body []byte, contentType string, raw bool) {
+	if raw {
+		os.Stdout.Write(body)
+		return
+	}
+
+	if len(body) == 0 {
+		fmt.Println("(no body)")
+	} else if !utf8.Valid(body) {
+		fmt.Println("(" + strconv.Itoa(len(body)) + " bytes of binary data, use --raw to show)")
+	} else {
+		formatted := formatBody(body, contentType)
+		fmt.Print(string(formatted))
+		if !bytes.HasSuffix(formatted, []byte("\n")) {
+			fmt.Println()
+		}
+	}
+}
+
+// Indent JSON and XML bodies, leaving other bodies and invalid documents unchanged
+func formatBody(body []byte, contentType string) []byte {
+	mediaType := parseMediaType(contentType)
+	if isJsonContentType(contentType) {
+		var indented bytes.Buffer
+		err := json.Indent(&indented, bytes.TrimSpace(body), "", "  ")
+		if err == nil {
+			return indented.Bytes()
+		}
+	} else if mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml") {
+		indented, err := indentXml(body)
+		if err == nil {
+			return indented
+		}
+	}
+	return body
+}
+
+// Put each XML element on its own line, indented by depth.
+// Elements holding only text stay on one line.
+func indentXml(body []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	var indented bytes.Buffer
+	depth := 0
+	inlineEnd := false
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if startElement, ok := token.(xml.StartElement); ok {
+			writeXmlLine(&indented, depth, "<"+xmlName(startElement.Name))
+			for i, j := 0, len(startElement.Attr); i < j; i++ {
+				indented.WriteString(" " + xmlName(startElement.Attr[i].Name) + `="`)
+				xml.EscapeText(&indented, []byte(startElement.Attr[i].Value))
+				indented.WriteString(`"`)
+			}
+			indented.WriteString(">")
+			depth++
+			inlineEnd = true
+		} else if endElement, ok := token.(xml.EndElement); ok {
+			depth--
+			if inlineEnd {
+				indented.WriteString("</" + xmlName(endElement.Name) + ">")
+			} else {
+				writeXmlLine(&indented, depth, "</"+xmlName(endElement.Name)+">")
+			}
+			inlineEnd = false
+		} else if charData, ok := token.(xml.CharData); ok {
+			text := bytes.TrimSpace(charData)
+			if len(text) > 0 {
+				xml.EscapeText(&indented, text)
+			}
+		} else if comment, ok := token.(xml.Comment); ok {
+			writeXmlLine(&indented, depth, "<!--"+string(comment)+"-->")
+			inlineEnd = false
+		} else if procInst, ok := token.(xml.ProcInst); ok {
+			writeXmlLine(&indented, depth, "<?"+procInst.Target+" "+string(procInst.Inst)+"?>")
+			inlineEnd = false
+		} else if directive, ok := token.(xml.Directive); ok {
+			writeXmlLine(&indented, depth, "<!"+string(directive)+">")
+			inlineEnd = false
+		}
+	}
+
+	if depth != 0 {
+		return nil, errors.New("Unclosed XML elements.")
+	}
+	indented.WriteString("\n")
+	return bytes.TrimLeft(indented.Bytes(), "\n"), nil
+}
+
+func writeXmlLine(indented *bytes.Buffer, depth int, s string) {
+	indented.WriteString("\n" + strings.Repeat("  ", depth) + s)
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,194 @@
+package application
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Headers whose values are never written to history
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Query and form parameters whose values are never written to history
+var redactedParams = []string{"api_key", "apikey", "api-key", "access_token", "token", "password", "secret", "client_secret"}
+
+// JSON fields whose string values are never written to history
+var redactedJsonFields = []string{"password", "passwd", "secret", "client_secret", "access_token", "refresh_token", "api_key", "apikey"}
+
+// Matches the password in a URL's user info
+var userinfoPasswordRegexp = regexp.MustCompile(`(://[^/:@\s]*:)[^@/\s]+@`)
+
+// User-configured redaction, added to the built-in rules
+type RedactionRules struct {
+	Headers    []string
+	Params     []string
+	JsonFields []string
+	Patterns   []string
+}
+
+// Removes secrets from records before they are saved to history
+type historyRedactor struct {
+	headers     map[string]bool
+	paramRegexp *regexp.Regexp
+	jsonRegexp  *regexp.Regexp
+	patterns    []*regexp.Regexp
+}
+
+//
+//	Private functions
+//
+
+// Combine the built-in redaction rules with user-configured ones
+func newHistoryRedactor(rules RedactionRules) (*historyRedactor, error) {
+	redactor := &historyRedactor{headers: make(map[string]bool)}
+
+	headers := append(append([]string{}, redactedHeaders...), rules.Headers...)
+	for i, j := 0, len(headers); i < j; i++ {
+		redactor.headers[http.CanonicalHeaderKey(headers[i])] = true
+	}
+
+	params := append(append([]string{}, redactedParams...), rules.Params...)
+	fields := append(append([]string{}, redactedJsonFields...), rules.JsonFields...)
+	redactor.paramRegexp = regexp.MustCompile(`(?i)((?:^|[?&;\s'"])(?:` + quoteAll(params) + `)=)[^&#;\s'"]*`)
+	redactor.jsonRegexp = regexp.MustCompile(`(?i)("(?:` + quoteAll(fields) + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+	for i, j := 0, len(rules.Patterns); i < j; i++ {
+		pattern, err := regexp.Compile(rules.Patterns[i])
+		if err != nil {
+			return redactor, errors.New("Invalid redaction pattern '" + rules.Patterns[i] + "': " + err.Error())
+		}
+		redactor.patterns = append(redactor.patterns, pattern)
+	}
+	return redactor, nil
+}
+
+// Copy a record with secrets replaced, leaving the original untouched
+func (redactor *historyRedactor) redact(historyApp *Application) Application {
+	redacted := *historyApp
+
+	redacted.Args = make([]string, len(historyApp.Args))
+	for i, j := 0, len(historyApp.Args); i < j; i++ {
+		redacted.Args[i] = redactor.redactString(historyApp.Args[i])
+	}
+
+	if historyApp.Request.URL != nil {
+		requestUrl := *historyApp.Request.URL
+		requestUrl.RawQuery = redactor.redactString(requestUrl.RawQuery)
+		// User info does not survive json, and its password must not be saved
+		requestUrl.User = nil
+		redacted.Request.URL = &requestUrl
+	}
+	redacted.Request.Header = redactor.redactHeader(historyApp.Request.Header)
+	redacted.Request.Body, redacted.Request.ContentLength = redactor.redactBody(historyApp.Request.Body, historyApp.Request.ContentType, historyApp.Request.ContentLength)
+	if historyApp.Request.Template != nil {
+		template := *historyApp.Request.Template
+		template.URL = redactor.redactString(template.URL)
+		template.Header = redactor.redactHeader(template.Header)
+		template.Body = redactor.redactString(template.Body)
+		redacted.Request.Template = &template
+	}
+	redacted.Response.Header = redactor.redactHeader(historyApp.Response.Header)
+	redacted.Response.Body, redacted.Response.ContentLength = redactor.redactBody(historyApp.Response.Body, historyApp.Response.ContentType, historyApp.Response.ContentLength)
+	return redacted
+}
+
+// Copy headers with sensitive values replaced
+func (redactor *historyRedactor) redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		redactedValues := make([]string, len(values))
+		for i, j := 0, len(values); i < j; i++ {
+			if redactor.headers[http.CanonicalHeaderKey(key)] {
+				redactedValues[i] = redactedValue
+			} else {
+				redactedValues[i] = redactor.redactString(values[i])
+			}
+		}
+		redacted[key] = redactedValues
+	}
+	return redacted
+}
+
+// Copy of parsed arguments with the values of --auth, --bearer and sensitive header options replaced
+func (redactor *historyRedactor) redactArgs(opts *OptionSet, args []string) []string {
+	redacted := opts.RedactArgs(args, redactedValue, "auth", "bearer")
+	redacted = opts.MapArgs(redacted, "header", redactor.redactHeaderOption)
+	return opts.MapArgs(redacted, "session-header", redactor.redactHeaderOption)
+}
+
+// Replace the value of a "Name: value" header option with a sensitive name
+func (redactor *historyRedactor) redactHeaderOption(value string) string {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" || !redactor.headers[http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))] {
+		return value
+	}
+	return parts[0] + ": " + redactedValue
+}
+
+// Redact a textual body, returning it with its new length. Other bodies are left as they are.
+func (redactor *historyRedactor) redactBody(body []byte, contentType string, contentLength int) ([]byte, int) {
+	if len(body) == 0 || !isTextContentType(contentType) {
+		return body, contentLength
+	}
+	redacted := redactor.redactBytes(body)
+	return redacted, len(redacted)
+}
+
+func (redactor *historyRedactor) redactString(s string) string {
+	return string(redactor.redactBytes([]byte(s)))
+}
+
+// Replace secret parameters, JSON fields and configured patterns
+func (redactor *historyRedactor) redactBytes(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	redacted := userinfoPasswordRegexp.ReplaceAll(data, []byte("${1}"+redactedValue+"@"))
+	redacted = redactor.paramRegexp.ReplaceAll(redacted, []byte("${1}"+redactedValue))
+	redacted = redactor.jsonRegexp.ReplaceAll(redacted, []byte(`${1}"`+redactedValue+`"`))
+	for i, j := 0, len(redactor.patterns); i < j; i++ {
+		redacted = redactor.patterns[i].ReplaceAll(redacted, []byte(redactedValue))
+	}
+	return redacted
+}
+
+// Drop headers that were redacted in history, and warn about other redacted values a replay would send
+func warnRedactedRequest(request *Request) {
+	for key, values := range request.Header {
+		for i, j := 0, len(values); i < j; i++ {
+			if strings.Contains(values[i], redactedValue) {
+				fmt.Println("Warning: header " + key + " was redacted in history and is not replayed.")
+				request.Header.Del(key)
+				break
+			}
+		}
+	}
+
+	if request.URL != nil && strings.Contains(request.URL.RawQuery, redactedValue) {
+		fmt.Println("Warning: the URL has query parameters that were redacted in history.")
+	}
+	if request.NoHistoryBody && request.ContentLength > 0 && len(request.Body) == 0 {
+		fmt.Println("Warning: the request body was not saved to history and is not replayed.")
+		request.ContentLength = 0
+	}
+	if bytes.Contains(request.Body, []byte(redactedValue)) {
+		fmt.Println("Warning: the request body has values that were redacted in history.")
+	}
+}
+
+// Join names as a regular expression alternation
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, j := 0, len(names); i < j; i++ {
+		quoted[i] = regexp.QuoteMeta(names[i])
+	}
+	return strings.Join(quoted, "|")
+}
@@ -0,0 +1,96 @@
+package application
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRedactorRedact(t *testing.T) {
+	redactor, err := newHistoryRedactor(RedactionRules{Headers: []string{"X-Api-Key"}, Patterns: []string{"sk-[a-z0-9]+"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requestUrl, _ := url.Parse("https://user:pw@example.com/a?token=abc&page=2")
+	binaryBody := []byte("\x00password=abc\xff")
+	historyApp := &Application{
+		Args: []string{"get", "https://example.com/a?token=abc"},
+		Request: Request{
+			URL:           requestUrl,
+			Header:        http.Header{"Authorization": {"Bearer abc"}, "X-Api-Key": {"k"}, "Accept-Language": {"en"}},
+			ContentType:   "application/json",
+			Body:          []byte(`{"password": "hunter2", "name": "sk-abc123"}`),
+			ContentLength: 44,
+		},
+		Response: Response{
+			Header:        http.Header{"Set-Cookie": {"sid=1"}},
+			ContentType:   "application/octet-stream",
+			Body:          binaryBody,
+			ContentLength: len(binaryBody),
+		},
+	}
+	redacted := redactor.redact(historyApp)
+
+	tests := []struct {
+		what     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"args", redacted.Args, []string{"get", "https://example.com/a?token=[redacted]"}},
+		{"query", redacted.Request.URL.RawQuery, "token=[redacted]&page=2"},
+		{"user info", redacted.Request.URL.User, (*url.Userinfo)(nil)},
+		{"authorization", redacted.Request.Header.Get("Authorization"), "[redacted]"},
+		{"configured header", redacted.Request.Header.Get("X-Api-Key"), "[redacted]"},
+		{"other header", redacted.Request.Header.Get("Accept-Language"), "en"},
+		{"json body", string(redacted.Request.Body), `{"password": "[redacted]", "name": "[redacted]"}`},
+		{"json body length", redacted.Request.ContentLength, len(redacted.Request.Body)},
+		{"set-cookie", redacted.Response.Header.Get("Set-Cookie"), "[redacted]"},
+		{"binary body", redacted.Response.Body, binaryBody},
+		{"binary body length", redacted.Response.ContentLength, len(binaryBody)},
+	}
+	for i, j := 0, len(tests); i < j; i++ {
+		if !reflect.DeepEqual(tests[i].value, tests[i].expected) {
+			t.Errorf("%s: got %#v, expected %#v", tests[i].what, tests[i].value, tests[i].expected)
+		}
+	}
+
+	// The original record is left as it was
+	if historyApp.Request.Header.Get("Authorization") != "Bearer abc" || historyApp.Request.URL.RawQuery != "token=abc&page=2" {
+		t.Errorf("redact changed the original record")
+	}
+}
+
+func TestHistoryRedactorRedactArgs(t *testing.T) {
+	redactor, err := newHistoryRedactor(RedactionRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"get", "url", "-H", "Authorization: Bearer x"}, []string{"get", "url", "-H", "Authorization: [redacted]"}},
+		{[]string{"get", "url", "--header=Authorization: Bearer x"}, []string{"get", "url", "--header=Authorization: [redacted]"}},
+		{[]string{"get", "url", "-HAuthorization: Bearer x"}, []string{"get", "url", "-HAuthorization: [redacted]"}},
+		{[]string{"get", "url", "-H", "proxy-authorization:x"}, []string{"get", "url", "-H", "proxy-authorization: [redacted]"}},
+		{[]string{"get", "url", "-H", "Authorization:"}, []string{"get", "url", "-H", "Authorization:"}},
+		{[]string{"get", "url", "-H", "X-Trace: 1"}, []string{"get", "url", "-H", "X-Trace: 1"}},
+		{[]string{"get", "url", "--auth", "u:p", "--bearer=t"}, []string{"get", "url", "--auth", "[redacted]", "--bearer=[redacted]"}},
+		{[]string{"get", "Authorization: Bearer x"}, []string{"get", "Authorization: Bearer x"}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		opts := newHttpOptionSet()
+		err := opts.Parse(tests[i].args)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tests[i].args, err)
+		}
+		redacted := redactor.redactArgs(opts, tests[i].args)
+		if !reflect.DeepEqual(redacted, tests[i].expected) {
+			t.Errorf("%q: redacted = %q, expected %q", tests[i].args, redacted, tests[i].expected)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Outcome of replaying one record in a batch
+type replayResult struct {
+	Original *Application
+	Replay   *Application
+	Err      error
+}
+
+//
+//	Private functions
+//
+
+// Options overriding fields of a replayed request
+func addReplayOverrideOptions(opts *OptionSet) {
+	opts.String("", "url", "URL", "", "Send to URL instead of the recorded one")
+	opts.String("", "host", "[https://]HOST[:PORT]", "", "Send to host, keeping the recorded path and query")
+	opts.List("H", "header", "'X-Api-Key: value'", "Set a request header; 'Name:' removes it")
+	opts.String("d", "data", "'{\"key\": \"value\"}'", "", "Use data as request body")
+	opts.Int("t", "timeout", "SECONDS", 60, "Response header timeout")
+	opts.String("o", "output", "/path/to/output/file.json", "", "Save response body to file")
+	opts.Bool("p", "print", "Print response body")
+	opts.String("u", "auth", "USER:PASS", "", "Authenticate with username and password")
+	opts.String("", "auth-type", "basic|digest", "basic", "Authentication scheme for --auth")
+	opts.String("", "bearer", "TOKEN", "", "Authenticate with a bearer token")
+}
+
+// Set up the request to replay a record, applying overrides and session options
+func (app *Application) prepareReplay(historyApp *Application, opts *OptionSet) error {
+	app.Request = historyApp.Request
+	app.ReplayOf = historyApp.Id
+	// Replays check responses but do not change variables for later requests
+	app.Request.Extract = nil
+	err := app.overrideReplayRequest(opts)
+	if err != nil {
+		return err
+	}
+	warnRedactedRequest(&app.Request)
+	err = app.loadCredential(app.Request.Auth)
+	if err != nil {
+		return err
+	}
+	if opts.Flag("check-status") {
+		app.Request.CheckStatus = true
+	}
+	if opts.Value("session") != "" {
+		app.Request.Session = opts.Value("session")
+	} else if !opts.Flag("reuse-session") {
+		app.Request.Session = ""
+	}
+	return nil
+}
+
+// Replay filtered history records oldest first, and summarize how their statuses changed
+func (app *Application) runBatchReplay(opts *OptionSet) error {
+	if opts.Value("output") != "" || opts.Flag("print") {
+		return errors.New("--output and --print can only be used when replaying one record.")
+	}
+	parallel := opts.IntValue("parallel")
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	query, err := getHistoryQuery(opts)
+	if err != nil {
+		return err
+	}
+	// --host overrides the host of replayed requests rather than filtering
+	query.Host = ""
+	entries, _, _, err := app.history.Query(query)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New("No history records match the filters.")
+	}
+
+	results := make([]replayResult, len(entries))
+	for i, j := 0, len(entries); i < j; i++ {
+		historyApp, err := app.history.Load(entries[j-1-i])
+		if err != nil {
+			return err
+		}
+		results[i].Original = &historyApp
+	}
+
+	// Requests are sent concurrently, but saved and reported one at a time. Records are
+	// prepared and their sessions opened first, so workers share one copy of each session
+	// and save it under the mutex.
+	var mutex sync.Mutex
+	replayApps := make([]*Application, len(results))
+	sessionApps := make(map[string]*Application)
+	for i, j := 0, len(results); i < j; i++ {
+		replayApp := app.newRunApp()
+		replayApp.sessionMutex = &mutex
+		replayApps[i] = replayApp
+		results[i].Err = replayApp.prepareReplay(results[i].Original, opts)
+		if results[i].Err != nil || replayApp.Request.Session == "" {
+			continue
+		}
+		if sessionApp, present := sessionApps[replayApp.Request.Session]; present {
+			replayApp.useSession(sessionApp.session, sessionApp.cookieJar)
+		} else {
+			results[i].Err = replayApp.openSession()
+			sessionApps[replayApp.Request.Session] = replayApp
+		}
+	}
+
+	numDone := 0
+	indexes := make(chan int)
+	var waitGroup sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for index := range indexes {
+				result := &results[index]
+				result.Replay = replayApps[index]
+				if result.Err == nil {
+					result.Err = result.Replay.replayOne(&mutex)
+				}
+
+				mutex.Lock()
+				numDone++
+				printReplayResult(result, numDone, len(results))
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i, j := 0, len(results); i < j; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	waitGroup.Wait()
+
+	return summarizeReplays(results, opts.Flag("check-status"))
+}
+
+// Send one prepared record of a batch replay, saving it while holding the mutex
+func (app *Application) replayOne(mutex *sync.Mutex) error {
+	app.Request.PrintResponse = false
+	err := app.SendRequest()
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return app.SaveApp()
+}
+
+func printReplayResult(result *replayResult, numDone int, numTotal int) {
+	request := result.Original.Request
+	progress := "[" + strconv.Itoa(numDone) + "/" + strconv.Itoa(numTotal) + "]"
+	if result.Err != nil {
+		fmt.Println(progress, request.Method, request.URL, "failed:", result.Err.Error())
+		return
+	}
+
+	status := strconv.Itoa(result.Original.Response.StatusCode) + " -> " + strconv.Itoa(result.Replay.Response.StatusCode)
+	fmt.Println(progress, request.Method, request.URL, status, "("+roundDuration(result.Replay.Duration).String()+")")
+}
+
+// Print counts of unchanged, changed and failed replays, with details of the changes and failures
+func summarizeReplays(results []replayResult, checkStatus bool) error {
+	changed := make([]string, 0)
+	failed := make([]string, 0)
+	num4xx, num5xx := 0, 0
+	for i, j := 0, len(results); i < j; i++ {
+		original := results[i].Original
+		description := original.Id + " " + original.Request.Method + " " + original.Request.URL.String()
+		if results[i].Err != nil {
+			failed = append(failed, description+": "+results[i].Err.Error())
+			continue
+		}
+
+		response := results[i].Replay.Response
+		if response.StatusCode != original.Response.StatusCode {
+			changed = append(changed, description+": "+original.Response.Status+" -> "+response.Status)
+		}
+		if response.StatusCode >= 500 {
+			num5xx++
+		} else if response.StatusCode >= 400 {
+			num4xx++
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Replayed", len(results), "records:", len(results)-len(changed)-len(failed), "unchanged,",
+		len(changed), "changed,", len(failed), "failed.")
+	if len(changed) > 0 {
+		fmt.Println("Status changes:")
+		for i, j := 0, len(changed); i < j; i++ {
+			fmt.Println("\t" + changed[i])
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Println("Failures:")
+		for i, j := 0, len(failed); i < j; i++ {
+			fmt.Println("\t" + failed[i])
+		}
+		return errors.New(strconv.Itoa(len(failed)) + " of " + strconv.Itoa(len(results)) + " replays failed.")
+	}
+
+	if checkStatus && num5xx > 0 {
+		return &ExitError{Code: ExitHttp5xx, Message: strconv.Itoa(num5xx) + " replays had server error responses."}
+	} else if checkStatus && num4xx > 0 {
+		return &ExitError{Code: ExitHttp4xx, Message: strconv.Itoa(num4xx) + " replays had client error responses."}
+	}
+	return nil
+}
+
+// Determine if any option selecting history records was given
+func hasHistoryFilters(opts *OptionSet) bool {
+	filters := []string{"find", "method", "status", "since", "until", "min-duration", "regex", "body-contains", "limit", "skip"}
+	for i, j := 0, len(filters); i < j; i++ {
+		if opts.Provided(filters[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace fields of the request being replayed with those given as options
+func (app *Application) overrideReplayRequest(opts *OptionSet) error {
+	request := &app.Request
+	if opts.Value("url") != "" && opts.Value("host") != "" {
+		return errors.New("Use either --url or --host, not both.")
+	}
+
+	if opts.Value("url") != "" {
+		requestUrl, err := url.Parse(opts.Value("url"))
+		if err != nil {
+			return errors.New("Error parsing URL: " + err.Error())
+		}
+		requestUrl.RawQuery = requestUrl.Query().Encode()
+		request.URL = requestUrl
+	} else if opts.Value("host") != "" {
+		requestUrl := *request.URL
+		host := opts.Value("host")
+		if strings.Contains(host, "://") {
+			hostUrl, err := url.Parse(host)
+			if err != nil || hostUrl.Host == "" {
+				return errors.New("Invalid host '" + host + "'. Expected HOST[:PORT] or SCHEME://HOST[:PORT].")
+			}
+			requestUrl.Scheme = hostUrl.Scheme
+			host = hostUrl.Host
+		}
+		requestUrl.Host = host
+		request.URL = &requestUrl
+	}
+
+	// Headers are replaced in a copy, leaving the loaded record untouched
+	headerOpts := opts.Values("header")
+	if len(headerOpts) > 0 {
+		header := request.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		overrides, err := parseHeaders(headerOpts)
+		if err != nil {
+			return err
+		}
+		for key := range overrides {
+			header.Del(key)
+		}
+		for key, values := range overrides {
+			for i, j := 0, len(values); i < j; i++ {
+				if values[i] != "" {
+					header.Add(key, values[i])
+				}
+			}
+		}
+		request.Header = header
+	}
+
+	if opts.Provided("data") {
+		request.Body = []byte(opts.Value("data"))
+		request.ContentLength = len(request.Body)
+	}
+	if opts.Provided("timeout") {
+		request.Timeout = opts.IntValue("timeout")
+		if request.Timeout < 1 {
+			request.Timeout = 60
+		}
+	}
+	if opts.Value("auth") != "" || opts.Value("bearer") != "" {
+		auth, err := newAuth(opts.Value("auth"), opts.Value("auth-type"), opts.Value("bearer"))
+		if err != nil {
+			return err
+		}
+		request.Auth = auth
+	}
+	if opts.Flag("print") {
+		request.PrintResponse = true
+	}
+	if opts.Value("output") != "" {
+		app.OutputFilePath = opts.Value("output")
+	}
+	// The request as written no longer describes what is sent
+	if opts.Value("url") != "" || opts.Value("host") != "" || len(headerOpts) > 0 || opts.Provided("data") {
+		request.Template = nil
+	}
+	return nil
+}
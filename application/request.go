@@ -2,80 +2,102 @@ package application
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Data about the request to send
 type Request struct {
-	Method        string
-	URL           *url.URL
-	Timeout       int
-	ContentType   string
-	Accept        string
-	ContentLength int
-	Body          []byte
-	PrintResponse bool
+	Method         string
+	URL            *url.URL
+	Timeout        int
+	ContentType    string
+	Accept         string
+	Header         http.Header
+	Auth           *Auth
+	ContentLength  int
+	Body           []byte
+	BodyBlob       string
+	NoHistoryBody  bool
+	PrintResponse  bool
+	CheckStatus    bool
+	Session        string
+	SessionHeaders []string
+	Insecure       bool
+	Environment    string
+	Template       *RequestTemplate
+	Extract        []string
 }
 
 // Response data
 type Response struct {
+	StatusCode    int
+	Status        string
+	Proto         string
+	Header        http.Header
+	Timings       Timings
 	ContentType   string
 	ContentLength int
 	Body          []byte
+	BodyBlob      string
+}
+
+// Options for HTTP request commands
+func newHttpOptionSet() *OptionSet {
+	opts := NewOptionSet("HTTP", "[REQUESTMETHOD] URL FLAGS")
+	opts.Bool("j", "json", "Send request body as application/json")
+	opts.String("c", "content-type", "application/json", "", "Request content type")
+	opts.String("a", "accept", "application/json", "*/*", "Accepted response content type")
+	opts.Int("t", "timeout", "SECONDS", 60, "Response header timeout")
+	opts.String("i", "input", "/path/to/input/file.json", "", "Use file as request body")
+	opts.String("o", "output", "/path/to/output/file.json", "", "Save response body to file")
+	opts.String("d", "data", "'{\"key\": \"value\"}'", "", "Use data as request body")
+	opts.Bool("p", "print", "Print response body")
+	opts.Bool("", "check-status", "Exit with an error code on 4xx and 5xx responses")
+	opts.List("H", "header", "'X-Api-Key: value'", "Custom request header")
+	opts.String("u", "auth", "USER:PASS", "", "Authenticate with username and password")
+	opts.String("", "auth-type", "basic|digest", "basic", "Authentication scheme for --auth")
+	opts.String("", "bearer", "TOKEN", "", "Authenticate with a bearer token")
+	opts.String("", "session", "NAME", "", "Keep cookies, headers and auth in a named session")
+	opts.List("", "session-header", "'X-Api-Key: value'", "Set a request header and keep it in the session")
+	opts.Bool("k", "insecure", "Skip TLS certificate verification")
+	opts.Bool("", "no-body", "Do not save request and response bodies to history")
+	opts.String("", "env", "NAME", "", "Fill {{variable}} templates from a named environment")
+	opts.List("", "extract", "NAME=$.path|header:NAME|regex:RE", "Save a response value to the session or environment")
+	return opts
 }
 
 // Parse command line arguments
 func (app *Application) CreateRequest() error {
 	fmt.Println("Parsing arguments...")
 
-	inputFlagMap := map[string]bool{
-		"-i":      true,
-		"--input": true,
-	}
-	outputFlagMap := map[string]bool{
-		"-o":       true,
-		"--output": true,
-	}
-	jsonFlagMap := map[string]bool{
-		"-j":     true,
-		"--json": true,
-	}
-	printFlagMap := map[string]bool{
-		"-p":     true,
-		"--print": true,
-	}
-	contentTypeOptMap := map[string]bool{
-		"-c":             true,
-		"--content-type": true,
-	}
-	acceptOptMap := map[string]bool{
-		"-a":       true,
-		"--accept": true,
-	}
-	timeoutOptMap := map[string]bool{
-		"-t":        true,
-		"--timeout": true,
-	}
-	dataOptMap := map[string]bool{
-		"-d":     true,
-		"--data": true,
+	opts := newHttpOptionSet()
+	err := opts.Parse(app.Args)
+	if err != nil {
+		return err
 	}
+	args := opts.Args()
+	// Keep secrets out of the arguments saved to history
+	app.Args = app.redactor.redactArgs(opts, app.Args)
 
 	requestMethod := app.RequestMethods[0]
 	requestMethodProvided := false
-	for i, j := 0, len(app.RequestMethods); i < j; i++ {
-		if app.RequestMethods[i] == strings.ToUpper(app.Args[0]) {
-			requestMethod = strings.ToUpper(app.Args[0])
+	for i, j := 0, len(app.RequestMethods); i < j && len(args) > 0; i++ {
+		if app.RequestMethods[i] == strings.ToUpper(args[0]) {
+			requestMethod = strings.ToUpper(args[0])
 			requestMethodProvided = true
 			break
 		}
@@ -85,10 +107,31 @@ func (app *Application) CreateRequest() error {
 	if requestMethodProvided {
 		urlIndex = 1
 	}
-	if len(app.Args) < urlIndex+1 {
+	if len(args) < urlIndex+1 {
 		return errors.New("Invalid arguments. Try 'gohttp help' for usage details.")
+	} else if len(args) > urlIndex+1 {
+		return errors.New("Unexpected argument '" + args[urlIndex+1] + "'. Try 'gohttp help' for usage details.")
+	}
+	environment, err := app.loadEnvironment(opts.Value("env"))
+	if err != nil {
+		return err
+	}
+	// Variables saved to the session by --extract are available to templates
+	var session *Session
+	if opts.Value("session") != "" {
+		session, err = app.loadSession(opts.Value("session"))
+		if err != nil {
+			return err
+		}
 	}
-	requestUrl, err := url.Parse(app.Args[urlIndex])
+	resolver := newTemplateResolver(environment, session)
+	template := &RequestTemplate{URL: args[urlIndex]}
+
+	urlString, err := resolver.resolve(args[urlIndex], "the URL")
+	if err != nil {
+		return err
+	}
+	requestUrl, err := url.Parse(urlString)
 	if err != nil {
 		return errors.New("Error parsing URL: " + err.Error())
 	}
@@ -96,19 +139,27 @@ func (app *Application) CreateRequest() error {
 	query := requestUrl.Query()
 	requestUrl.RawQuery = query.Encode()
 
-	inputFilePath := app.getOption(inputFlagMap, "")
-	outputFilePath := app.getOption(outputFlagMap, "")
-	jsonContentType := app.flagIsActive(jsonFlagMap)
-	printFlag := app.flagIsActive(printFlagMap)
-	contentType := app.getOption(contentTypeOptMap, "")
-	acceptOpt := app.getOption(acceptOptMap, "")
-	dataOpt := app.getOption(dataOptMap, "")
-	timeoutOpt := app.getOption(timeoutOptMap, "0")
-	timeout, err := strconv.Atoi(timeoutOpt)
-	if err != nil || timeout < 1 {
+	inputFilePath := opts.Value("input")
+	outputFilePath := opts.Value("output")
+	jsonContentType := opts.Flag("json")
+	printFlag := opts.Flag("print")
+	checkStatus := opts.Flag("check-status")
+	contentType := opts.Value("content-type")
+	accept := opts.Value("accept")
+	dataOpt := opts.Value("data")
+	headerOpts := opts.Values("header")
+	timeout := opts.IntValue("timeout")
+	if timeout < 1 {
 		timeout = 60
 	}
 
+	// Headers given by --session-header are sent like others and kept in the session
+	sessionHeaders, err := parseSessionHeaders(opts.Values("session-header"), opts.Value("session"))
+	if err != nil {
+		return err
+	}
+	headerOpts = append(headerOpts, opts.Values("session-header")...)
+
 	contentLength := 0
 	requestData := make([]byte, 0)
 	if requestMethod == "POST" || requestMethod == "PATCH" || requestMethod == "PUT" {
@@ -165,33 +216,100 @@ func (app *Application) CreateRequest() error {
 		requestContentType = "application/x-www-form-urlencoded"
 	}
 
-	accept := "*/*"
-	if acceptOpt != "" {
-		accept = acceptOpt
+	// Bodies that are not text, such as uploaded images, are sent as they are
+	if len(requestData) > 0 && utf8.Valid(requestData) {
+		body, err := resolver.resolve(string(requestData), "the request body")
+		if err != nil {
+			return err
+		}
+		if body != string(requestData) {
+			template.Body = string(requestData)
+			requestData = []byte(body)
+			contentLength = len(requestData)
+		}
+	}
+
+	header, err := parseHeaders(headerOpts)
+	if err != nil {
+		return err
+	}
+	templateHeader := header.Clone()
+	for key, values := range header {
+		for i, j := 0, len(values); i < j; i++ {
+			values[i], err = resolver.resolve(values[i], "the "+key+" header")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !reflect.DeepEqual(header, templateHeader) {
+		template.Header = templateHeader
+	}
+
+	authOpt, err := resolver.resolve(opts.Value("auth"), "--auth")
+	if err != nil {
+		return err
+	}
+	bearerOpt, err := resolver.resolve(opts.Value("bearer"), "--bearer")
+	if err != nil {
+		return err
+	}
+	auth, err := newAuth(authOpt, opts.Value("auth-type"), bearerOpt)
+	if err != nil {
+		return err
+	}
+	// The template is only kept when something was filled in
+	if !resolver.changed {
+		template = nil
 	}
 
 	app.InputFilePath = inputFilePath
 	app.OutputFilePath = outputFilePath
 
 	app.Request = Request{
-		Method:        requestMethod,
-		URL:           requestUrl,
-		Timeout:       timeout,
-		ContentType:   requestContentType,
-		Accept:        accept,
-		ContentLength: contentLength,
-		PrintResponse: printFlag,
-		Body:          requestData,
+		Method:         requestMethod,
+		URL:            requestUrl,
+		Timeout:        timeout,
+		ContentType:    requestContentType,
+		Accept:         accept,
+		Header:         header,
+		Auth:           auth,
+		ContentLength:  contentLength,
+		PrintResponse:  printFlag,
+		CheckStatus:    checkStatus,
+		Session:        opts.Value("session"),
+		SessionHeaders: sessionHeaders,
+		Insecure:       opts.Flag("insecure"),
+		NoHistoryBody:  opts.Flag("no-body"),
+		Environment:    opts.Value("env"),
+		Template:       template,
+		Extract:        opts.Values("extract"),
+		Body:           requestData,
 	}
 
-	return nil
+	return validateExtract(&app.Request)
 }
 
 // Send HTTP request
 func (app *Application) SendRequest() error {
 	fmt.Println("Sending request...")
 
-	err := app.loadAndSendHttpRequest()
+	err := app.openSession()
+	if err != nil {
+		return err
+	}
+
+	err = app.loadAndSendHttpRequest()
+	if err != nil {
+		return err
+	}
+
+	err = app.extractVariables()
+	if err != nil {
+		return err
+	}
+
+	err = app.saveSession()
 	if err != nil {
 		return err
 	}
@@ -228,26 +346,80 @@ func (app *Application) SendRequest() error {
 //
 
 // Create an HTTP request given an app request
-func (app *Application) loadAndSendHttpRequest() error {
+func (app *Application) newHttpRequest() (*http.Request, error) {
 	requestData := bytes.NewReader(app.Request.Body)
 	req, err := http.NewRequest(app.Request.Method, app.Request.URL.String(), requestData)
 	if err != nil {
-		return errors.New("Error making new request object: " + err.Error())
+		return req, errors.New("Error making new request object: " + err.Error())
+	}
+	req.Header = app.Request.fullHeader()
+	return req, nil
+}
+
+// Headers sent with a request, from its content type, accept, custom headers and auth
+func (request *Request) fullHeader() http.Header {
+	header := http.Header{}
+	if request.ContentType != "" {
+		header.Add("Content-Type", request.ContentType)
 	}
-	if app.Request.ContentType != "" {
-		req.Header.Add("Content-Type", app.Request.ContentType)
+	if request.Accept != "" {
+		header.Add("Accept", request.Accept)
 	}
-	if app.Request.Accept != "" {
-		req.Header.Add("Accept", app.Request.Accept)
+	// Custom headers replace any defaults set above
+	for key, values := range request.Header {
+		header.Del(key)
+		for i, j := 0, len(values); i < j; i++ {
+			header.Add(key, values[i])
+		}
+	}
+	if request.Auth != nil {
+		request.Auth.apply(header)
+	}
+	return header
+}
+
+// Send an HTTP request and read the response
+func (app *Application) loadAndSendHttpRequest() error {
+	req, err := app.newHttpRequest()
+	if err != nil {
+		return err
 	}
 
 	transport := &http.Transport{
 		ResponseHeaderTimeout: time.Duration(app.Request.Timeout) * time.Second,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: app.Request.Insecure},
 	}
-	client := &http.Client{Transport: transport}
+	client := &http.Client{Transport: transport, Jar: app.cookieJar}
+	recorder := newTimingRecorder()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), recorder.clientTrace()))
 	resp, err := client.Do(req)
 	if err != nil {
-		return errors.New("Error sending request: " + err.Error())
+		return newSendError(err)
+	}
+
+	// Digest auth answers the server's challenge with a second request
+	challenge := resp.Header.Get("WWW-Authenticate")
+	auth := app.Request.Auth
+	if auth != nil && auth.Type == "digest" && resp.StatusCode == http.StatusUnauthorized &&
+		strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		resp.Body.Close()
+
+		req, err = app.newHttpRequest()
+		if err != nil {
+			return err
+		}
+		authorization, err := auth.digestAuthorization(req, challenge, app.Request.Body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+
+		recorder.restart()
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), recorder.clientTrace()))
+		resp, err = client.Do(req)
+		if err != nil {
+			return newSendError(err)
+		}
 	}
 	defer resp.Body.Close()
 
@@ -255,11 +427,17 @@ func (app *Application) loadAndSendHttpRequest() error {
 	if err != nil {
 		return errors.New("Error reading response body: " + err.Error())
 	}
+	timings := recorder.finish()
 
 	contentType := resp.Header.Get("Content-Type")
 
 	numResponseBytes := len(responseData)
 	app.Response = Response{
+		StatusCode:    resp.StatusCode,
+		Status:        resp.Status,
+		Proto:         resp.Proto,
+		Header:        resp.Header,
+		Timings:       timings,
 		ContentType:   contentType,
 		ContentLength: numResponseBytes,
 		Body:          responseData,
@@ -267,6 +445,20 @@ func (app *Application) loadAndSendHttpRequest() error {
 	return nil
 }
 
+// Parse "Name: value" header options into a header map
+func parseHeaders(headerOpts []string) (http.Header, error) {
+	header := http.Header{}
+	for i, j := 0, len(headerOpts); i < j; i++ {
+		parts := strings.SplitN(headerOpts[i], ":", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) < 2 || key == "" {
+			return header, errors.New("Invalid header '" + headerOpts[i] + "'. Expected format is 'Name: value'.")
+		}
+		header.Add(key, strings.TrimSpace(parts[1]))
+	}
+	return header, nil
+}
+
 func (app *Application) saveToOutputFile() error {
 	if app.OutputFilePath != "" {
 		dirName := filepath.Dir(app.OutputFilePath)
@@ -288,7 +480,7 @@ func (app *Application) saveToOutputFile() error {
 			return errors.New("Error writing json data to file: " + err.Error())
 		}
 
-		if numBytesWritten < app.Response.ContentLength {
+		if numBytesWritten < len(app.Response.Body) {
 			return errors.New("Error writing data to output file: Not all data written to file.")
 		}
 
@@ -0,0 +1,214 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matches a size such as 500MB or 1.5 GB
+var sizeRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGT]?I?B?)$`)
+
+// Limits resolved from options or a retention policy
+type retentionLimits struct {
+	MaxAge     time.Duration
+	MaxRecords int
+	MaxSize    int64
+}
+
+// Delete history records by index or ID
+func (app *Application) RunHistoryDelete(opts *OptionSet) error {
+	args := opts.Args()
+	if len(args) < 1 {
+		return errors.New("Missing history record index or ID.")
+	}
+
+	// Resolve every argument before deleting, since deleting renumbers indexes
+	ids := make([]string, 0, len(args))
+	for i, j := 0, len(args); i < j; i++ {
+		entry, err := app.findHistoryEntry(args[i])
+		if err != nil {
+			return err
+		}
+		ids = append(ids, entry.Id)
+	}
+
+	err := app.history.Delete(ids)
+	if err != nil {
+		return err
+	}
+	// Rewrite history so the deleted records and their bodies are removed from disk
+	err = app.history.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Deleted", len(ids), "history records.")
+	return nil
+}
+
+// Delete all history records
+func (app *Application) RunHistoryClear(opts *OptionSet) error {
+	stats, err := app.history.Stats()
+	if err != nil {
+		return err
+	}
+
+	if !opts.Flag("yes") {
+		fmt.Print("Clear all " + strconv.Itoa(stats.Records) + " history records? y/N  ")
+		var s string
+		fmt.Scanln(&s)
+		if s != "y" && s != "Y" {
+			fmt.Println("History was not cleared.")
+			return nil
+		}
+	}
+
+	err = app.history.Clear()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Cleared", stats.Records, "history records.")
+	return nil
+}
+
+// Delete history records beyond retention limits and reclaim their space
+func (app *Application) RunHistoryPrune(opts *OptionSet) error {
+	policy := app.config.Retention
+	if opts.Provided("older-than") || opts.Provided("max-records") || opts.Provided("max-size") {
+		policy = RetentionPolicy{
+			MaxAge:     opts.Value("older-than"),
+			MaxRecords: opts.IntValue("max-records"),
+			MaxSize:    opts.Value("max-size"),
+		}
+	}
+
+	limits, err := parseRetentionPolicy(policy)
+	if err != nil {
+		return err
+	}
+	if limits == (retentionLimits{}) {
+		return errors.New("No retention limits given. Use --older-than, --max-records or --max-size, or set Retention in " + app.ConfigPath + ".")
+	}
+
+	numDeleted, err := app.applyRetention(limits, time.Now())
+	if err != nil {
+		return err
+	}
+
+	before, err := app.history.Stats()
+	if err != nil {
+		return err
+	}
+	err = app.history.Compact()
+	if err != nil {
+		return err
+	}
+	after, err := app.history.Stats()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Pruned", numDeleted, "history records, reclaimed", formatSize(int(before.TotalBytes+before.BlobBytes-after.TotalBytes-after.BlobBytes))+".", after.Records, "records remain.")
+	return nil
+}
+
+//
+//	Private functions
+//
+
+// Enforce the configured retention policy after saving a record
+func (app *Application) enforceRetention() error {
+	limits, err := parseRetentionPolicy(app.config.Retention)
+	if err != nil {
+		return err
+	}
+	if limits == (retentionLimits{}) {
+		return nil
+	}
+
+	numDeleted, err := app.applyRetention(limits, time.Now())
+	if err != nil || numDeleted == 0 {
+		return err
+	}
+	// Expired records are removed from disk, not only from the index
+	return app.history.Compact()
+}
+
+// Delete records beyond the limits, returning how many were deleted
+func (app *Application) applyRetention(limits retentionLimits, now time.Time) (int, error) {
+	entries, _, _, err := app.history.Query(HistoryQuery{})
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0)
+	var keptSize int64
+	numKept := 0
+	for i, j := 0, len(entries); i < j; i++ {
+		entry := entries[i]
+		if limits.MaxAge > 0 && now.Sub(entry.StartTime) > limits.MaxAge ||
+			limits.MaxRecords > 0 && numKept >= limits.MaxRecords ||
+			limits.MaxSize > 0 && keptSize+entry.Length+entry.BlobBytes > limits.MaxSize {
+			ids = append(ids, entry.Id)
+		} else {
+			numKept++
+			keptSize += entry.Length + entry.BlobBytes
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return len(ids), app.history.Delete(ids)
+}
+
+// Resolve a retention policy's text values
+func parseRetentionPolicy(policy RetentionPolicy) (retentionLimits, error) {
+	limits := retentionLimits{MaxRecords: policy.MaxRecords}
+	if limits.MaxRecords < 0 {
+		return limits, errors.New("Invalid retention record limit: " + strconv.Itoa(policy.MaxRecords))
+	}
+
+	var err error
+	if policy.MaxAge != "" {
+		limits.MaxAge, err = parseLongDuration(policy.MaxAge)
+		if err != nil {
+			return limits, err
+		}
+	}
+	if policy.MaxSize != "" {
+		limits.MaxSize, err = parseSize(policy.MaxSize)
+		if err != nil {
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+// Parse a byte size such as 500MB, using 1024 based units
+func parseSize(value string) (int64, error) {
+	match := sizeRegexp.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if match == nil {
+		return 0, errors.New("Invalid size '" + value + "'. Expected a number of bytes or KB, MB, GB.")
+	}
+
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, errors.New("Invalid size '" + value + "'.")
+	}
+
+	unit := float64(1)
+	if strings.HasPrefix(match[2], "K") {
+		unit = 1024
+	} else if strings.HasPrefix(match[2], "M") {
+		unit = 1024 * 1024
+	} else if strings.HasPrefix(match[2], "G") {
+		unit = 1024 * 1024 * 1024
+	} else if strings.HasPrefix(match[2], "T") {
+		unit = 1024 * 1024 * 1024 * 1024
+	}
+	return int64(number * unit), nil
+}
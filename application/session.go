@@ -0,0 +1,271 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Named session kept between invocations
+type Session struct {
+	Name      string
+	Cookies   []SessionCookie
+	Header    http.Header
+	Auth      *Auth
+	Variables map[string]string
+}
+
+// Cookie received in a session, with the URL of the response that set it
+type SessionCookie struct {
+	URL    string
+	Cookie http.Cookie
+}
+
+// Cookie jar that records cookies into a session as they are set. A session shared
+// by concurrent requests is changed while holding its mutex.
+type sessionJar struct {
+	jar     *cookiejar.Jar
+	session *Session
+	mutex   *sync.Mutex
+}
+
+func (sj *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	sj.jar.SetCookies(u, cookies)
+	if sj.mutex != nil {
+		sj.mutex.Lock()
+		defer sj.mutex.Unlock()
+	}
+	sj.session.addCookies(u, cookies)
+}
+
+func (sj *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	return sj.jar.Cookies(u)
+}
+
+//
+//	Private functions
+//
+
+var sessionNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// Headers a session never keeps, since credentials belong in the credential store
+var unsessionedHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// Names of the headers given by --session-header, which are kept in the session
+func parseSessionHeaders(sessionHeaderOpts []string, sessionName string) ([]string, error) {
+	if len(sessionHeaderOpts) == 0 {
+		return nil, nil
+	} else if sessionName == "" {
+		return nil, errors.New("--session-header keeps a header in the session given by --session.")
+	}
+
+	header, err := parseHeaders(sessionHeaderOpts)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(header))
+	for key := range header {
+		for i, j := 0, len(unsessionedHeaders); i < j; i++ {
+			if key == unsessionedHeaders[i] {
+				return nil, errors.New("Sessions do not keep the " + key + " header. Use --auth or --bearer, which keep credentials in the credential store.")
+			}
+		}
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load the request's session, or start a new one, and merge its defaults into the request.
+// A session that is already open, as in a batch replay, is kept.
+func (app *Application) openSession() error {
+	if app.Request.Session == "" || app.session != nil {
+		return nil
+	}
+	session, err := app.loadSession(app.Request.Session)
+	if err != nil {
+		return err
+	}
+	err = app.loadCredential(session.Auth)
+	if err != nil {
+		return err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return errors.New("Error creating cookie jar: " + err.Error())
+	}
+	session.pruneCookies()
+	for i, j := 0, len(session.Cookies); i < j; i++ {
+		cookieUrl, err := url.Parse(session.Cookies[i].URL)
+		if err == nil {
+			cookie := session.Cookies[i].Cookie
+			jar.SetCookies(cookieUrl, []*http.Cookie{&cookie})
+		}
+	}
+
+	app.useSession(session, &sessionJar{jar: jar, session: session, mutex: app.sessionMutex})
+	return nil
+}
+
+// Send the request in an open session, merging its headers and auth into the request
+func (app *Application) useSession(session *Session, cookieJar http.CookieJar) {
+	// Session headers and auth are defaults that the request can override
+	if app.Request.Header == nil {
+		app.Request.Header = http.Header{}
+	}
+	for key, values := range session.Header {
+		if _, present := app.Request.Header[key]; !present {
+			app.Request.Header[key] = values
+		}
+	}
+	if app.Request.Auth == nil && session.Auth != nil {
+		auth := *session.Auth
+		app.Request.Auth = &auth
+	}
+
+	app.session = session
+	app.cookieJar = cookieJar
+}
+
+// Read a named session, or a new empty session if it has not been saved yet
+func (app *Application) loadSession(name string) (*Session, error) {
+	if !sessionNameRegexp.MatchString(name) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("Invalid session name '" + name + "'. Use letters, numbers, '.', '_' and '-'.")
+	}
+
+	session := &Session{Name: name, Cookies: make([]SessionCookie, 0), Header: http.Header{}}
+	fileName := name + ".json"
+	jsonBytes, err := ioutil.ReadFile(path.Join(app.SessionsPath, fileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New("Error reading session file " + fileName + ": " + err.Error())
+	} else if err == nil {
+		err = json.Unmarshal(jsonBytes, session)
+		if err != nil {
+			return nil, errors.New("Error unmarshalling session json: " + err.Error())
+		}
+		if session.Header == nil {
+			session.Header = http.Header{}
+		}
+		// Sessions saved by earlier versions kept every request header
+		for i, j := 0, len(unsessionedHeaders); i < j; i++ {
+			session.Header.Del(unsessionedHeaders[i])
+		}
+	}
+	return session, nil
+}
+
+// Remember the request's session headers and auth in its session and save it
+func (app *Application) saveSession() error {
+	session := app.session
+	if session == nil {
+		return nil
+	}
+	if app.sessionMutex != nil {
+		app.sessionMutex.Lock()
+		defer app.sessionMutex.Unlock()
+	}
+
+	for i, j := 0, len(app.Request.SessionHeaders); i < j; i++ {
+		key := app.Request.SessionHeaders[i]
+		session.Header[key] = app.Request.Header[key]
+	}
+	if app.Request.Auth != nil {
+		err := app.storeCredential(app.Request.Auth)
+		if err != nil {
+			return err
+		}
+		session.Auth = app.Request.Auth
+	}
+	session.pruneCookies()
+
+	jsonBytes, err := json.Marshal(session)
+	if err != nil {
+		return errors.New("Error creating session json: " + err.Error())
+	}
+
+	fileName := session.Name + ".json"
+	err = ioutil.WriteFile(path.Join(app.SessionsPath, fileName), jsonBytes, 0600)
+	if err != nil {
+		return errors.New("Error writing session file " + fileName + ": " + err.Error())
+	}
+	return nil
+}
+
+// Add or replace cookies, converting relative lifetimes to absolute expiry times
+func (session *Session) addCookies(u *url.URL, cookies []*http.Cookie) {
+	now := time.Now()
+	for i, j := 0, len(cookies); i < j; i++ {
+		cookie := *cookies[i]
+		cookie.Raw = ""
+		deleted := cookie.MaxAge < 0
+		if cookie.MaxAge > 0 {
+			cookie.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+			cookie.MaxAge = 0
+		}
+		added := SessionCookie{URL: u.String(), Cookie: cookie}
+
+		kept := make([]SessionCookie, 0, len(session.Cookies)+1)
+		for k, l := 0, len(session.Cookies); k < l; k++ {
+			if session.Cookies[k].key() != added.key() {
+				kept = append(kept, session.Cookies[k])
+			}
+		}
+		if !deleted {
+			kept = append(kept, added)
+		}
+		session.Cookies = kept
+	}
+}
+
+// Identify a cookie by name, domain and path, as the jar does
+func (sc SessionCookie) key() string {
+	domain := sc.Cookie.Domain
+	cookiePath := sc.Cookie.Path
+	u, err := url.Parse(sc.URL)
+	if err == nil {
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		if cookiePath == "" {
+			cookiePath = defaultCookiePath(u)
+		}
+	}
+	return sc.Cookie.Name + ";" + strings.TrimPrefix(strings.ToLower(domain), ".") + ";" + cookiePath
+}
+
+// Drop expired cookies
+func (session *Session) pruneCookies() {
+	now := time.Now()
+	kept := make([]SessionCookie, 0, len(session.Cookies))
+	for i, j := 0, len(session.Cookies); i < j; i++ {
+		expires := session.Cookies[i].Cookie.Expires
+		if expires.IsZero() || expires.After(now) {
+			kept = append(kept, session.Cookies[i])
+		}
+	}
+	session.Cookies = kept
+}
+
+// Cookie path used when a response does not set one (RFC 6265 section 5.1.4)
+func defaultCookiePath(u *url.URL) string {
+	urlPath := u.Path
+	if urlPath == "" || urlPath[0] != '/' {
+		return "/"
+	}
+	lastSlash := strings.LastIndex(urlPath, "/")
+	if lastSlash == 0 {
+		return "/"
+	}
+	return urlPath[:lastSlash]
+}
@@ -0,0 +1,968 @@
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage backend for history records
+type HistoryStore interface {
+	// Save a record and return its index entry
+	Append(historyApp *Application) (HistoryEntry, error)
+	// Find index entries, newest first, with the number of matches and of all records
+	Query(query HistoryQuery) ([]HistoryEntry, int, int, error)
+	// Load the full record for an index entry
+	Load(entry HistoryEntry) (Application, error)
+	// Remove records by ID; their space is reclaimed by Compact
+	Delete(ids []string) error
+	// Remove all records
+	Clear() error
+	// Rewrite storage without deleted records
+	Compact() error
+	// Rewrite storage encrypted with a new cipher, or unencrypted when nil
+	Rekey(historyCipher *historyCipher) error
+	// Sizes of the store
+	Stats() (HistoryStats, error)
+}
+
+// Index metadata for one history record
+type HistoryEntry struct {
+	Index     int `json:"-"`
+	Id        string
+	StartTime time.Time
+	Duration  time.Duration
+	Method    string
+	URL       string
+	Host      string
+	Status    int
+	Size      int
+	Offset    int64
+	Length    int64
+	Blobs     []string `json:",omitempty"`
+	BlobBytes int64    `json:",omitempty"`
+	Deleted   bool     `json:",omitempty"`
+}
+
+// Sizes of a history store
+type HistoryStats struct {
+	Records    int
+	LiveBytes  int64
+	TotalBytes int64
+	BlobBytes  int64
+}
+
+// Criteria for finding history records; zero values match everything
+type HistoryQuery struct {
+	Skip            int
+	Limit           int
+	Id              string
+	Find            string
+	CaseInsensitive bool
+	Method          string
+	Host            string
+	StatusMin       int
+	StatusMax       int
+	Since           time.Time
+	Until           time.Time
+	MinDuration     time.Duration
+	URLRegexp       *regexp.Regexp
+	BodyContains    string
+}
+
+// A lock file older than this was left by a process that stopped without removing it
+const historyLockStaleAge = 10 * time.Minute
+
+// How long to wait for another process to finish writing history
+const historyLockTimeout = 30 * time.Second
+
+// Files a rewrite swaps in once its journal is written: the compacted records and index,
+// and for a rekey the new encryption metadata
+type rewriteJournal struct {
+	Metadata string
+}
+
+// Matches history files from older versions, named for their start time, method and URL
+var legacyHistoryFileRegexp = regexp.MustCompile(`^\d{4}_\d{2}_\d{2}_\d{2}_\d{2}_\d{2}__[A-Za-z]+__.*\.json$`)
+
+// History store keeping records in an append-only log with a separate log of index entries
+type logHistoryStore struct {
+	dirPath     string
+	recordsPath string
+	indexPath   string
+	journalPath string
+	redactor    *historyRedactor
+	encryption  EncryptionConfig
+	cipher      *historyCipher
+	cipherErr   error
+	cipherReady bool
+	cipherMutex sync.Mutex
+}
+
+//
+//	Private functions
+//
+
+// Open the history store in a directory, migrating any history files from older versions.
+// Records are redacted before they are written, and encrypted if configured.
+func newLogHistoryStore(dirPath string, redactor *historyRedactor, encryption EncryptionConfig) (*logHistoryStore, error) {
+	store := &logHistoryStore{
+		dirPath:     dirPath,
+		recordsPath: path.Join(dirPath, "records.log"),
+		indexPath:   path.Join(dirPath, "index.log"),
+		journalPath: path.Join(dirPath, "rewrite.journal"),
+		redactor:    redactor,
+		encryption:  encryption,
+	}
+
+	err := store.recoverRewrite()
+	if err != nil {
+		return store, err
+	}
+
+	err = store.migrateJsonFiles()
+	if err != nil {
+		return store, err
+	}
+	return store, nil
+}
+
+func (store *logHistoryStore) Append(historyApp *Application) (HistoryEntry, error) {
+	if historyApp.Id == "" {
+		id, err := newRecordId(historyApp.StartTime)
+		if err != nil {
+			return HistoryEntry{}, errors.New("Error creating history record id: " + err.Error())
+		}
+		historyApp.Id = id
+	}
+	redactedApp := store.redactor.redact(historyApp)
+
+	unlock, err := store.lock()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	defer unlock()
+
+	historyCipher, err := store.loadCipher()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	blobBytes, err := store.storeBodies(&redactedApp, historyCipher)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	entry := newHistoryEntry(&redactedApp)
+	entry.BlobBytes = blobBytes
+
+	recordBytes, err := json.Marshal(redactedApp)
+	if err != nil {
+		return entry, errors.New("Error creating history record json: " + err.Error())
+	}
+
+	recordBytes, err = store.encodeLine(recordBytes)
+	if err != nil {
+		return entry, err
+	}
+	entry.Offset, err = appendLine(store.recordsPath, recordBytes)
+	if err != nil {
+		return entry, errors.New("Error writing history record: " + err.Error())
+	}
+	entry.Length = int64(len(recordBytes))
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return entry, errors.New("Error creating history index json: " + err.Error())
+	}
+
+	entryBytes, err = store.encodeLine(entryBytes)
+	if err != nil {
+		return entry, err
+	}
+	_, err = appendLine(store.indexPath, entryBytes)
+	if err != nil {
+		return entry, errors.New("Error writing history index: " + err.Error())
+	}
+	return entry, nil
+}
+
+func (store *logHistoryStore) Query(query HistoryQuery) ([]HistoryEntry, int, int, error) {
+	entries := make([]HistoryEntry, 0)
+
+	allEntries, err := store.readIndex()
+	if err != nil {
+		return entries, 0, 0, err
+	}
+
+	numMatched := 0
+	numTotal := len(allEntries)
+	for i := numTotal - 1; i >= 0; i-- {
+		entry := allEntries[i]
+		// Keep numbers consistent for history items, regardless of filtering
+		entry.Index = numTotal - i
+
+		if !query.matchesEntry(entry) {
+			continue
+		}
+		if query.BodyContains != "" {
+			historyApp, err := store.Load(entry)
+			if err != nil {
+				return entries, numMatched, numTotal, err
+			}
+			if !query.matchesBody(historyApp) {
+				continue
+			}
+		}
+
+		numMatched++
+		if numMatched > query.Skip && (query.Limit < 1 || len(entries) < query.Limit) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, numMatched, numTotal, nil
+}
+
+func (store *logHistoryStore) Load(entry HistoryEntry) (Application, error) {
+	historyApp := Application{}
+
+	file, err := os.Open(store.recordsPath)
+	if err != nil {
+		return historyApp, errors.New("Error opening history records: " + err.Error())
+	}
+	defer file.Close()
+
+	recordBytes := make([]byte, entry.Length)
+	_, err = file.ReadAt(recordBytes, entry.Offset)
+	if err != nil {
+		return historyApp, errors.New("Error reading history record: " + err.Error())
+	}
+
+	recordBytes, err = store.decodeLine(recordBytes)
+	if err != nil {
+		return historyApp, err
+	}
+	err = json.Unmarshal(recordBytes, &historyApp)
+	if err != nil {
+		return historyApp, errors.New("Error unmarshalling json: " + err.Error())
+	}
+	if historyApp.Id == "" {
+		historyApp.Id = entry.Id
+	}
+
+	err = store.loadBodies(&historyApp)
+	if err != nil {
+		return historyApp, err
+	}
+	return historyApp, nil
+}
+
+func (store *logHistoryStore) Delete(ids []string) error {
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i, j := 0, len(ids); i < j; i++ {
+		entryBytes, err := json.Marshal(HistoryEntry{Id: ids[i], Deleted: true})
+		if err != nil {
+			return errors.New("Error creating history index json: " + err.Error())
+		}
+
+		entryBytes, err = store.encodeLine(entryBytes)
+		if err != nil {
+			return err
+		}
+
+		_, err = appendLine(store.indexPath, entryBytes)
+		if err != nil {
+			return errors.New("Error writing history index: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (store *logHistoryStore) Clear() error {
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Remove the index first, so records are never listed without their data
+	err = os.Remove(store.indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New("Error removing history index: " + err.Error())
+	}
+
+	err = os.Remove(store.recordsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New("Error removing history records: " + err.Error())
+	}
+
+	err = os.RemoveAll(path.Join(store.dirPath, "blobs"))
+	if err != nil {
+		return errors.New("Error removing history bodies: " + err.Error())
+	}
+
+	// Earlier versions kept migrated history files here
+	err = os.RemoveAll(path.Join(store.dirPath, "migrated"))
+	if err != nil {
+		return errors.New("Error removing migrated history files: " + err.Error())
+	}
+	return nil
+}
+
+func (store *logHistoryStore) Compact() error {
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = store.rewrite(nil, false)
+	if err != nil {
+		return err
+	}
+	return store.collectBlobs()
+}
+
+func (store *logHistoryStore) Rekey(historyCipher *historyCipher) error {
+	// Hold the lock until the new key is saved, so no record is written with the old one
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Make sure existing history can be decrypted before rewriting it
+	_, err = store.loadCipher()
+	if err != nil {
+		return err
+	}
+
+	// The new metadata is swapped in with the records, so history is never left
+	// encrypted with a key its metadata does not describe
+	err = store.rewrite(historyCipher, true)
+	if err != nil {
+		return err
+	}
+	store.cipher = historyCipher
+
+	// Blobs encrypted with the old key are no longer referenced
+	return store.collectBlobs()
+}
+
+func (store *logHistoryStore) Stats() (HistoryStats, error) {
+	stats := HistoryStats{}
+
+	entries, err := store.readIndex()
+	if err != nil {
+		return stats, err
+	}
+	stats.Records = len(entries)
+	for i, j := 0, len(entries); i < j; i++ {
+		stats.LiveBytes += entries[i].Length + 1
+	}
+
+	recordsInfo, err := os.Stat(store.recordsPath)
+	if err == nil {
+		stats.TotalBytes = recordsInfo.Size()
+	} else if !os.IsNotExist(err) {
+		return stats, errors.New("Error reading history records: " + err.Error())
+	}
+
+	stats.BlobBytes, err = store.blobsSize()
+	return stats, err
+}
+
+// Copy live records to new files and swap them in. Lines are kept as they are,
+// unless recode is set to decode them and encode them with a new cipher, whose
+// metadata is swapped in along with them. The caller holds the lock, so no record
+// is appended between the renames.
+func (store *logHistoryStore) rewrite(historyCipher *historyCipher, recode bool) error {
+	_, err := os.Stat(store.indexPath)
+	if os.IsNotExist(err) && !recode {
+		return nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return errors.New("Error reading history index: " + err.Error())
+	}
+
+	written, err := store.writeCompacted(historyCipher, recode)
+	if err != nil {
+		os.Remove(written[0])
+		os.Remove(written[1])
+		return err
+	}
+
+	journal := rewriteJournal{}
+	if recode && historyCipher != nil {
+		journal.Metadata = "replace"
+		err = writeEncryptionMetadata(store.metadataPath()+".rekey", historyCipher)
+	} else if recode {
+		journal.Metadata = "remove"
+	}
+	if err != nil {
+		store.removeRewriteFiles()
+		return err
+	}
+
+	// Once the journal is written the rewrite is committed, and an interrupted swap
+	// is finished when history is next opened
+	journalBytes, err := json.Marshal(journal)
+	if err != nil {
+		store.removeRewriteFiles()
+		return errors.New("Error creating history rewrite journal: " + err.Error())
+	}
+	err = writeSyncedFile(store.journalPath+".new", journalBytes)
+	if err == nil {
+		err = os.Rename(store.journalPath+".new", store.journalPath)
+	}
+	if err != nil {
+		os.Remove(store.journalPath + ".new")
+		store.removeRewriteFiles()
+		return errors.New("Error writing history rewrite journal: " + err.Error())
+	}
+	return store.finishRewrite()
+}
+
+// Swap in the files of a committed rewrite, then remove its journal. Files already
+// swapped in by an interrupted run are skipped, so this can be repeated.
+func (store *logHistoryStore) finishRewrite() error {
+	journalBytes, err := ioutil.ReadFile(store.journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Error reading history rewrite journal: " + err.Error())
+	}
+	journal := rewriteJournal{}
+	err = json.Unmarshal(journalBytes, &journal)
+	if err != nil {
+		return errors.New("Error unmarshalling history rewrite journal: " + err.Error())
+	}
+
+	err = renameIfExists(store.recordsPath+".compact", store.recordsPath)
+	if err != nil {
+		return errors.New("Error replacing history records: " + err.Error())
+	}
+	err = renameIfExists(store.indexPath+".compact", store.indexPath)
+	if err != nil {
+		return errors.New("Error replacing history index: " + err.Error())
+	}
+	if journal.Metadata == "replace" {
+		err = renameIfExists(store.metadataPath()+".rekey", store.metadataPath())
+	} else if journal.Metadata == "remove" {
+		err = os.Remove(store.metadataPath())
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return errors.New("Error replacing history encryption metadata: " + err.Error())
+	}
+
+	err = os.Remove(store.journalPath)
+	if err != nil {
+		return errors.New("Error removing history rewrite journal: " + err.Error())
+	}
+	return nil
+}
+
+// Finish a rewrite that was interrupted after its journal was written, or drop the
+// files of one interrupted before, which left history as it was
+func (store *logHistoryStore) recoverRewrite() error {
+	sidePaths := []string{store.journalPath, store.recordsPath + ".compact", store.indexPath + ".compact", store.metadataPath() + ".rekey"}
+	found := false
+	for i, j := 0, len(sidePaths); i < j && !found; i++ {
+		_, err := os.Stat(sidePaths[i])
+		found = err == nil
+	}
+	if !found {
+		return nil
+	}
+
+	// Another process may be in the middle of a rewrite, so check again under the lock
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = os.Stat(store.journalPath)
+	if os.IsNotExist(err) {
+		store.removeRewriteFiles()
+		return nil
+	}
+	err = store.finishRewrite()
+	if err != nil {
+		return err
+	}
+	// Blobs written with a replaced key are only removed once the swap is done
+	return store.collectBlobs()
+}
+
+// Remove the files of a rewrite that was not committed
+func (store *logHistoryStore) removeRewriteFiles() {
+	os.Remove(store.recordsPath + ".compact")
+	os.Remove(store.indexPath + ".compact")
+	os.Remove(store.metadataPath() + ".rekey")
+}
+
+func (store *logHistoryStore) metadataPath() string {
+	return path.Join(store.dirPath, "encryption.json")
+}
+
+// Take the lock file that keeps other processes from writing history, waiting for it
+// if needed. Returns a function that releases it.
+func (store *logHistoryStore) lock() (func(), error) {
+	lockPath := path.Join(store.dirPath, "lock")
+	deadline := time.Now().Add(historyLockTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		} else if !os.IsExist(err) {
+			return nil, errors.New("Error locking history: " + err.Error())
+		}
+
+		lockInfo, err := os.Stat(lockPath)
+		if err == nil && time.Since(lockInfo.ModTime()) > historyLockStaleAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("History is locked by another process. If none is running, remove " + lockPath + ".")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Copy live records and their index entries to new files, returning their paths
+func (store *logHistoryStore) writeCompacted(historyCipher *historyCipher, recode bool) ([2]string, error) {
+	written := [2]string{store.recordsPath + ".compact", store.indexPath + ".compact"}
+
+	entries, err := store.readIndex()
+	if err != nil {
+		return written, err
+	}
+
+	records, err := os.Open(store.recordsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return written, errors.New("Error opening history records: " + err.Error())
+	} else if err == nil {
+		defer records.Close()
+	}
+
+	recordsFile, err := os.OpenFile(written[0], os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return written, errors.New("Error creating compacted history records: " + err.Error())
+	}
+	defer recordsFile.Close()
+	indexFile, err := os.OpenFile(written[1], os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return written, errors.New("Error creating compacted history index: " + err.Error())
+	}
+	defer indexFile.Close()
+
+	recordsWriter := bufio.NewWriter(recordsFile)
+	indexWriter := bufio.NewWriter(indexFile)
+	var offset int64
+	for i, j := 0, len(entries); i < j; i++ {
+		recordBytes := make([]byte, entries[i].Length)
+		_, err = records.ReadAt(recordBytes, entries[i].Offset)
+		if err != nil {
+			return written, errors.New("Error reading history record: " + err.Error())
+		}
+		if recode {
+			recordBytes, err = store.decodeLine(recordBytes)
+			if err != nil {
+				return written, err
+			}
+			if len(entries[i].Blobs) > 0 {
+				recordBytes, err = store.recodeBodies(&entries[i], recordBytes, historyCipher)
+				if err != nil {
+					return written, err
+				}
+			}
+			recordBytes, err = encodeLine(historyCipher, recordBytes)
+			if err != nil {
+				return written, err
+			}
+		}
+		recordsWriter.Write(recordBytes)
+		recordsWriter.WriteByte('\n')
+
+		entries[i].Offset = offset
+		entries[i].Length = int64(len(recordBytes))
+		offset += entries[i].Length + 1
+		entryBytes, err := json.Marshal(entries[i])
+		if err != nil {
+			return written, errors.New("Error creating history index json: " + err.Error())
+		}
+		if recode {
+			entryBytes, err = encodeLine(historyCipher, entryBytes)
+		} else {
+			entryBytes, err = store.encodeLine(entryBytes)
+		}
+		if err != nil {
+			return written, err
+		}
+		indexWriter.Write(entryBytes)
+		indexWriter.WriteByte('\n')
+	}
+
+	err = recordsWriter.Flush()
+	if err != nil {
+		return written, errors.New("Error writing compacted history records: " + err.Error())
+	}
+	err = indexWriter.Flush()
+	if err != nil {
+		return written, errors.New("Error writing compacted history index: " + err.Error())
+	}
+
+	// Both files must be on disk before the journal commits the swap
+	err = recordsFile.Sync()
+	if err != nil {
+		return written, errors.New("Error writing compacted history records: " + err.Error())
+	}
+	err = indexFile.Sync()
+	if err != nil {
+		return written, errors.New("Error writing compacted history index: " + err.Error())
+	}
+	return written, nil
+}
+
+// Read all index entries, oldest first
+func (store *logHistoryStore) readIndex() ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+
+	file, err := os.Open(store.indexPath)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return entries, errors.New("Error opening history index: " + err.Error())
+	}
+	defer file.Close()
+
+	deletedIds := make(map[string]bool)
+	var decodeErr error
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			// Only the last line can be cut short by an interrupted write
+			if decodeErr != nil {
+				return entries, decodeErr
+			}
+			line, decodeErr = store.decodeLine(line)
+			if store.cipherErr != nil {
+				return entries, store.cipherErr
+			}
+
+			entry := HistoryEntry{}
+			if decodeErr == nil && json.Unmarshal(line, &entry) == nil {
+				if entry.Deleted {
+					deletedIds[entry.Id] = true
+				} else {
+					if entry.Id == "" {
+						entry.Id = legacyRecordId(entry.StartTime, entry.Offset)
+					}
+					entries = append(entries, entry)
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return entries, errors.New("Error reading history index: " + err.Error())
+		}
+	}
+
+	if len(deletedIds) == 0 {
+		return entries, nil
+	}
+	liveEntries := make([]HistoryEntry, 0, len(entries))
+	for i, j := 0, len(entries); i < j; i++ {
+		if !deletedIds[entries[i].Id] {
+			liveEntries = append(liveEntries, entries[i])
+		}
+	}
+	return liveEntries, nil
+}
+
+// Store a record's bodies again as blobs for a new cipher, returning the updated record
+func (store *logHistoryStore) recodeBodies(entry *HistoryEntry, recordBytes []byte, historyCipher *historyCipher) ([]byte, error) {
+	historyApp := Application{}
+	err := json.Unmarshal(recordBytes, &historyApp)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling json: " + err.Error())
+	}
+
+	err = store.loadBodies(&historyApp)
+	if err != nil {
+		return nil, err
+	}
+	entry.BlobBytes, err = store.storeBodies(&historyApp, historyCipher)
+	if err != nil {
+		return nil, err
+	}
+	entry.Blobs = recordBlobs(&historyApp)
+
+	recordBytes, err = json.Marshal(historyApp)
+	if err != nil {
+		return nil, errors.New("Error creating history record json: " + err.Error())
+	}
+	return recordBytes, nil
+}
+
+// Load the history cipher when first needed, since deriving a key is slow.
+// Concurrent replays may need it at once, so it is loaded under a mutex.
+func (store *logHistoryStore) loadCipher() (*historyCipher, error) {
+	store.cipherMutex.Lock()
+	defer store.cipherMutex.Unlock()
+	if !store.cipherReady {
+		store.cipher, store.cipherErr = loadHistoryCipher(store.dirPath, store.encryption)
+		store.cipherReady = true
+	}
+	return store.cipher, store.cipherErr
+}
+
+// Prepare a line for writing, encrypting it if history is encrypted
+func (store *logHistoryStore) encodeLine(data []byte) ([]byte, error) {
+	historyCipher, err := store.loadCipher()
+	if err != nil {
+		return nil, err
+	}
+	return encodeLine(historyCipher, data)
+}
+
+// Read a written line, decrypting it if it is encrypted
+func (store *logHistoryStore) decodeLine(line []byte) ([]byte, error) {
+	if !isEncryptedLine(line) {
+		return line, nil
+	}
+
+	historyCipher, err := store.loadCipher()
+	if err != nil {
+		return nil, err
+	} else if historyCipher == nil {
+		return nil, errors.New("History is encrypted, but its encryption metadata is missing.")
+	}
+	return historyCipher.open(line)
+}
+
+// Move history saved as one json file per record into the store
+func (store *logHistoryStore) migrateJsonFiles() error {
+	fileInfos, err := ioutil.ReadDir(store.dirPath)
+	if err != nil {
+		return errors.New("Error reading history directory: " + err.Error())
+	}
+
+	fileNames := make([]string, 0)
+	for i, j := 0, len(fileInfos); i < j; i++ {
+		fileName := fileInfos[i].Name()
+		if !fileInfos[i].IsDir() && legacyHistoryFileRegexp.MatchString(fileName) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	if len(fileNames) == 0 {
+		return nil
+	}
+
+	// File names only have one-second resolution, so order by start time and use names for ties
+	startTimes := make(map[string]time.Time, len(fileNames))
+	for i, j := 0, len(fileNames); i < j; i++ {
+		historyApp, err := store.readLegacyFile(fileNames[i])
+		if err != nil {
+			return err
+		}
+		startTimes[fileNames[i]] = historyApp.StartTime
+	}
+	sort.Slice(fileNames, func(a, b int) bool {
+		timeA, timeB := startTimes[fileNames[a]], startTimes[fileNames[b]]
+		if !timeA.Equal(timeB) {
+			return timeA.Before(timeB)
+		}
+		return fileNames[a] < fileNames[b]
+	})
+	fmt.Println("Migrating", len(fileNames), "history files to the history store...")
+
+	// Appending redacts and encrypts each record, so the unprotected originals are removed
+	for i, j := 0, len(fileNames); i < j; i++ {
+		historyApp, err := store.readLegacyFile(fileNames[i])
+		if err != nil {
+			return err
+		}
+
+		_, err = store.Append(&historyApp)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(path.Join(store.dirPath, fileNames[i]))
+		if err != nil {
+			return errors.New("Error removing migrated history file " + fileNames[i] + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (store *logHistoryStore) readLegacyFile(fileName string) (Application, error) {
+	historyApp := Application{}
+	fileData, err := ioutil.ReadFile(path.Join(store.dirPath, fileName))
+	if err != nil {
+		return historyApp, errors.New("Error reading history file " + fileName + ": " + err.Error())
+	}
+
+	err = json.Unmarshal(fileData, &historyApp)
+	if err != nil {
+		return historyApp, errors.New("Error unmarshalling history file " + fileName + ": " + err.Error())
+	}
+	return historyApp, nil
+}
+
+// Summarize a record for the index
+func newHistoryEntry(historyApp *Application) HistoryEntry {
+	entry := HistoryEntry{
+		Id:        historyApp.Id,
+		Blobs:     recordBlobs(historyApp),
+		StartTime: historyApp.StartTime,
+		Duration:  historyApp.Duration,
+		Method:    historyApp.Request.Method,
+		Status:    historyApp.Response.StatusCode,
+		Size:      historyApp.Response.ContentLength,
+	}
+	if historyApp.Request.URL != nil {
+		entry.URL = historyApp.Request.URL.String()
+		entry.Host = historyApp.Request.URL.Host
+	}
+	return entry
+}
+
+// Determine if an entry matches the query's index criteria
+func (query HistoryQuery) matchesEntry(entry HistoryEntry) bool {
+	if query.Id != "" && normalizeRecordId(query.Id) != entry.Id {
+		return false
+	}
+	if query.Find != "" {
+		text := entry.Method + " " + entry.URL
+		if query.CaseInsensitive {
+			if !strings.Contains(strings.ToLower(text), strings.ToLower(query.Find)) {
+				return false
+			}
+		} else if !strings.Contains(text, query.Find) {
+			return false
+		}
+	}
+	if query.Method != "" && !strings.EqualFold(entry.Method, query.Method) {
+		return false
+	}
+	if query.Host != "" && !matchesHost(entry.Host, query.Host) {
+		return false
+	}
+	if query.StatusMin > 0 && entry.Status < query.StatusMin {
+		return false
+	}
+	if query.StatusMax > 0 && entry.Status > query.StatusMax {
+		return false
+	}
+	if !query.Since.IsZero() && entry.StartTime.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && entry.StartTime.After(query.Until) {
+		return false
+	}
+	if query.MinDuration > 0 && entry.Duration < query.MinDuration {
+		return false
+	}
+	if query.URLRegexp != nil && !query.URLRegexp.MatchString(entry.URL) {
+		return false
+	}
+	return true
+}
+
+// Determine if a record's request or response body contains the query's text
+func (query HistoryQuery) matchesBody(historyApp Application) bool {
+	find := []byte(query.BodyContains)
+	return bytes.Contains(historyApp.Request.Body, find) || bytes.Contains(historyApp.Response.Body, find)
+}
+
+// Match a host with or without its port
+func matchesHost(entryHost string, host string) bool {
+	if strings.EqualFold(entryHost, host) {
+		return true
+	}
+	u := url.URL{Host: entryHost}
+	return strings.EqualFold(u.Hostname(), host)
+}
+
+// Encrypt a line with a cipher, or leave it as it is without one
+func encodeLine(historyCipher *historyCipher, data []byte) ([]byte, error) {
+	if historyCipher == nil {
+		return data, nil
+	}
+	return historyCipher.seal(data)
+}
+
+// Append a line to a file, returning the offset it was written at
+func appendLine(filePath string, data []byte) (int64, error) {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// A single write keeps concurrent appends from interleaving
+	line := append(data, '\n')
+	_, err = file.Write(line)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return end - int64(len(line)), nil
+}
+
+// Write a file and sync it to disk
+func writeSyncedFile(filePath string, data []byte) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Rename a file unless it is already gone, as after an earlier rename
+func renameIfExists(oldPath string, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package application
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, dirPath string, encryption EncryptionConfig) *logHistoryStore {
+	redactor, err := newHistoryRedactor(RedactionRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newLogHistoryStore(dirPath, redactor, encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func newTestRecord(rawUrl string, body string, startTime time.Time) *Application {
+	requestUrl, _ := url.Parse(rawUrl)
+	return &Application{
+		StartTime: startTime,
+		Mode:      "http",
+		Request: Request{
+			Method:      "GET",
+			URL:         requestUrl,
+			Header:      http.Header{"X-Trace": {"1"}},
+			ContentType: "application/json",
+		},
+		Response: Response{
+			StatusCode:    200,
+			Status:        "200 OK",
+			ContentType:   "application/json",
+			Body:          []byte(body),
+			ContentLength: len(body),
+		},
+	}
+}
+
+// Append records, returning the bodies by record id
+func appendTestRecords(t *testing.T, store *logHistoryStore) map[string]string {
+	bodies := map[string]string{}
+	startTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []struct {
+		url  string
+		body string
+	}{
+		{"https://example.com/one", `{"n": 1}`},
+		{"https://example.com/two", `{"items": "` + strings.Repeat("x", 1000) + `"}`},
+		{"https://other.example.com/three", `{"n": 3}`},
+	}
+	for i, j := 0, len(records); i < j; i++ {
+		historyApp := newTestRecord(records[i].url, records[i].body, startTime.Add(time.Duration(i)*time.Minute))
+		entry, err := store.Append(historyApp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[entry.Id] = records[i].body
+	}
+	return bodies
+}
+
+// Check that every record in the store loads with its body, and that they are the expected ones
+func checkTestRecords(t *testing.T, store *logHistoryStore, bodies map[string]string) {
+	entries, _, numTotal, err := store.Query(HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numTotal != len(bodies) {
+		t.Fatalf("store has %d records, expected %d", numTotal, len(bodies))
+	}
+	for i, j := 0, len(entries); i < j; i++ {
+		historyApp, err := store.Load(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, present := bodies[historyApp.Id]
+		if !present {
+			t.Errorf("unexpected record %s", historyApp.Id)
+		} else if string(historyApp.Response.Body) != body {
+			t.Errorf("record %s has body %q, expected %q", historyApp.Id, historyApp.Response.Body, body)
+		}
+	}
+}
+
+func TestLogHistoryStoreQuery(t *testing.T) {
+	store := newTestStore(t, t.TempDir(), EncryptionConfig{})
+	appendTestRecords(t, store)
+
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		urls  []string
+	}{
+		{"all, newest first", HistoryQuery{}, []string{"https://other.example.com/three", "https://example.com/two", "https://example.com/one"}},
+		{"limit", HistoryQuery{Limit: 1}, []string{"https://other.example.com/three"}},
+		{"skip", HistoryQuery{Skip: 2}, []string{"https://example.com/one"}},
+		{"host", HistoryQuery{Host: "example.com"}, []string{"https://example.com/two", "https://example.com/one"}},
+		{"find", HistoryQuery{Find: "two"}, []string{"https://example.com/two"}},
+		{"body", HistoryQuery{BodyContains: `"n": 3`}, []string{"https://other.example.com/three"}},
+		{"method", HistoryQuery{Method: "POST"}, []string{}},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		entries, _, _, err := store.Query(tests[i].query)
+		if err != nil {
+			t.Fatalf("%s: %v", tests[i].name, err)
+		}
+		urls := make([]string, len(entries))
+		for k, l := 0, len(entries); k < l; k++ {
+			urls[k] = entries[k].URL
+		}
+		if strings.Join(urls, " ") != strings.Join(tests[i].urls, " ") {
+			t.Errorf("%s: got %q, expected %q", tests[i].name, urls, tests[i].urls)
+		}
+	}
+}
+
+func TestLogHistoryStoreDeleteAndCompact(t *testing.T) {
+	dirPath := t.TempDir()
+	store := newTestStore(t, dirPath, EncryptionConfig{})
+	bodies := appendTestRecords(t, store)
+
+	entries, _, _, err := store.Query(HistoryQuery{Find: "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries[0].Blobs) == 0 {
+		t.Fatalf("large body was not stored as a blob")
+	}
+	err = store.Delete([]string{entries[0].Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(bodies, entries[0].Id)
+	checkTestRecords(t, store, bodies)
+
+	err = store.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestRecords(t, store, bodies)
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalBytes != stats.LiveBytes || stats.BlobBytes != 0 {
+		t.Errorf("compacted store has %d of %d bytes live and %d blob bytes", stats.LiveBytes, stats.TotalBytes, stats.BlobBytes)
+	}
+	recordsBytes, err := ioutil.ReadFile(path.Join(dirPath, "records.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(recordsBytes), "example.com/two") {
+		t.Errorf("deleted record is still in records.log")
+	}
+}
+
+func TestLogHistoryStoreRekey(t *testing.T) {
+	t.Setenv("GOHTTP_TEST_PASSPHRASE", "first secret")
+	encryption := EncryptionConfig{Enabled: true, PassphraseEnv: "GOHTTP_TEST_PASSPHRASE"}
+
+	dirPath := t.TempDir()
+	store := newTestStore(t, dirPath, encryption)
+	bodies := appendTestRecords(t, store)
+	checkTestRecords(t, store, bodies)
+
+	recordsBytes, err := ioutil.ReadFile(path.Join(dirPath, "records.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(recordsBytes), "example.com") {
+		t.Errorf("encrypted records.log holds plain text")
+	}
+
+	historyCipher, err := newHistoryCipher([]byte("second secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Rekey(historyCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestRecords(t, store, bodies)
+
+	// Reopened with the new secret, history is readable; the old one no longer works
+	t.Setenv("GOHTTP_TEST_PASSPHRASE", "second secret")
+	checkTestRecords(t, newTestStore(t, dirPath, encryption), bodies)
+	t.Setenv("GOHTTP_TEST_PASSPHRASE", "first secret")
+	_, _, _, err = newTestStore(t, dirPath, encryption).Query(HistoryQuery{})
+	if err == nil {
+		t.Errorf("history opened with the replaced secret")
+	}
+
+	err = store.Rekey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestRecords(t, newTestStore(t, dirPath, EncryptionConfig{}), bodies)
+	_, err = os.Stat(path.Join(dirPath, "encryption.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("decrypted history kept its encryption metadata")
+	}
+}
+
+func TestLogHistoryStoreRecoverRewrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		journal   bool
+		numLoaded int
+	}{
+		// A rewrite interrupted after its journal was written is finished
+		{"committed", true, 2},
+		// Without the journal, the rewrite's files are dropped and history is unchanged
+		{"uncommitted", false, 3},
+	}
+
+	for i, j := 0, len(tests); i < j; i++ {
+		dirPath := t.TempDir()
+		store := newTestStore(t, dirPath, EncryptionConfig{})
+		appendTestRecords(t, store)
+		entries, _, _, err := store.Query(HistoryQuery{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Delete([]string{entries[0].Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Stop a compaction after the records are swapped in, but before the index is
+		written, err := store.writeCompacted(nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tests[i].journal {
+			err = ioutil.WriteFile(store.journalPath, []byte("{}"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Rename(written[0], store.recordsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			// Undo the delete, so the dropped files would lose a record if swapped in
+			indexBytes, err := ioutil.ReadFile(store.indexPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.SplitAfter(strings.TrimSpace(string(indexBytes)), "\n")
+			err = ioutil.WriteFile(store.indexPath, []byte(strings.Join(lines[:len(lines)-1], "")), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reopened := newTestStore(t, dirPath, EncryptionConfig{})
+		_, _, numTotal, err := reopened.Query(HistoryQuery{})
+		if err != nil {
+			t.Fatalf("%s: %v", tests[i].name, err)
+		}
+		if numTotal != tests[i].numLoaded {
+			t.Errorf("%s: %d records after recovery, expected %d", tests[i].name, numTotal, tests[i].numLoaded)
+		}
+		sidePaths := []string{store.journalPath, written[0], written[1]}
+		for k, l := 0, len(sidePaths); k < l; k++ {
+			if _, err := os.Stat(sidePaths[k]); !os.IsNotExist(err) {
+				t.Errorf("%s: %s was left after recovery", tests[i].name, path.Base(sidePaths[k]))
+			}
+		}
+		checkEntries, _, _, err := reopened.Query(HistoryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, l := 0, len(checkEntries); k < l; k++ {
+			_, err = reopened.Load(checkEntries[k])
+			if err != nil {
+				t.Errorf("%s: %v", tests[i].name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package application
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Time spent in each phase of sending a request and reading its response
+type Timings struct {
+	Blocked time.Duration
+	DNS     time.Duration
+	Connect time.Duration
+	SSL     time.Duration
+	Send    time.Duration
+	Wait    time.Duration
+	Receive time.Duration
+}
+
+// Records phase timings through an httptrace.ClientTrace
+type timingRecorder struct {
+	mutex        sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	timings      Timings
+}
+
+//
+//	Private functions
+//
+
+func newTimingRecorder() *timingRecorder {
+	return &timingRecorder{start: time.Now()}
+}
+
+// Trace hooks that fill in the recorder's timings
+func (recorder *timingRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			recorder.mark(&recorder.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			recorder.measure(&recorder.timings.DNS, &recorder.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			recorder.mark(&recorder.connectStart)
+		},
+		ConnectDone: func(string, string, error) {
+			recorder.measure(&recorder.timings.Connect, &recorder.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			recorder.mark(&recorder.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			recorder.measure(&recorder.timings.SSL, &recorder.tlsStart)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			recorder.mark(&recorder.gotConn)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			recorder.mark(&recorder.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			recorder.mark(&recorder.firstByte)
+		},
+	}
+}
+
+// Restart timing for a follow-up request, such as a digest auth retry
+func (recorder *timingRecorder) restart() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.start = time.Now()
+	recorder.dnsStart = time.Time{}
+	recorder.connectStart = time.Time{}
+	recorder.tlsStart = time.Time{}
+	recorder.gotConn = time.Time{}
+	recorder.wroteRequest = time.Time{}
+	recorder.firstByte = time.Time{}
+	recorder.timings = Timings{}
+}
+
+// Finish timing once the response body has been read
+func (recorder *timingRecorder) finish() Timings {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	end := time.Now()
+	timings := recorder.timings
+	if !recorder.gotConn.IsZero() {
+		timings.Blocked = recorder.gotConn.Sub(recorder.start) - timings.DNS - timings.Connect - timings.SSL
+		if timings.Blocked < 0 {
+			timings.Blocked = 0
+		}
+	}
+	if !recorder.wroteRequest.IsZero() && !recorder.gotConn.IsZero() {
+		timings.Send = recorder.wroteRequest.Sub(recorder.gotConn)
+	}
+	if !recorder.firstByte.IsZero() && !recorder.wroteRequest.IsZero() {
+		timings.Wait = recorder.firstByte.Sub(recorder.wroteRequest)
+	}
+	if !recorder.firstByte.IsZero() {
+		timings.Receive = end.Sub(recorder.firstByte)
+	}
+	return timings
+}
+
+func (recorder *timingRecorder) mark(t *time.Time) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	*t = time.Now()
+}
+
+func (recorder *timingRecorder) measure(d *time.Duration, start *time.Time) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if !start.IsZero() {
+		*d = time.Since(*start)
+	}
+}
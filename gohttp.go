@@ -4,22 +4,61 @@ A command line HTTP request/response management tool in Go.
 	Features:
 		- Make GET, HEAD, PUT, POST, PATCH, DELETE requests easily
 		- Use files as request body
+		- Send custom request headers
+		- Basic, Digest and Bearer authentication, with secrets kept out of history
+		- Optional encryption of history at rest, with key rotation
+		- Redaction of secret headers, query parameters and JSON fields before history is saved
+		- Named sessions that keep cookies, auth and --session-header headers between requests
 		- Save response body to file
-		- Automatic history saving
-		- Filter and page history
-		- See details and replay requests from history
+		- Automatic history saving to an indexed store, migrating older history files
+		- Bodies in history stored compressed and deduplicated
+		- Readable text and JSON bodies in history records, with base64 kept for binary
+		- List history as a table, json or csv with selectable columns
+		- Filter and page history by text, method, host, status, time range, duration, URL pattern and body
+		- See details and replay requests from history by index or stable record ID
+		- Replay with a different URL, host, headers, body, timeout or auth, linked to the original record
+		- Batch replay filtered history, optionally in parallel, with a summary of status changes
+		- Show history headers and bodies, with JSON and XML pretty-printed, or a full record as json
+		- Diff two history records' status, headers, timing and bodies, structurally for JSON
+		- Delete, clear and prune history, with an optional retention policy
+		- Export history as curl commands or HAR 1.2
+		- Import requests from curl command lines and HAR files
+		- Save named requests in collections and run one or a whole collection
+		- Environments of variables and {{var}} templates in URLs, headers and bodies
+		- Extract response values into variables to chain requests
+
+	Flags can be given as --flag value or --flag=value, short flags can be
+	combined (-pj), and -- ends flag parsing.
 
 	Commands:
 		[help]
 		version
 		history
+		import
+		save
+		run
 		[REQUESTMETHOD] URL
 
 	History commands:
 		history [list] FLAGS
-		history detail 1
-		history replay 1
-		history save 1 /path/to/output/file.json
+		history detail [1 | ID] FLAGS
+		history replay [1 | ID | FILTERS] FLAGS
+		history save [1 | ID] /path/to/output/file.json
+		history name [1 | ID] NAME
+		history export [1 | 1-5 | ID] FLAGS
+		history diff [1 | ID] [2 | ID] FLAGS
+		history delete [1 | ID] ...
+		history clear FLAGS
+		history prune FLAGS
+		history rekey FLAGS
+
+	Import commands:
+		import curl ['curl ...' | -- curl ... | < file]
+		import har [file.har | < file]
+
+	Collection commands:
+		save NAME [REQUESTMETHOD] URL FLAGS
+		run [NAME | COLLECTION] FLAGS
 
 	HTTP Commands:
 		[get] URL FLAGS
@@ -30,20 +69,181 @@ A command line HTTP request/response management tool in Go.
 		delete URL FLAGS
 
 	History Flags:
+		(--columns) index,id,...
+		(--output) table|json|csv
 		(-f | --find) GET
 		(-i | --insensitive)
-		(-l | --limit) 10
-		(-s | --skip) 10
+		(--method) METHOD
+		(--host) HOST
+		(--status) 404|5xx|400-499
+		(--since) 2h|2006-01-02
+		(--until) 1d|2006-01-02
+		(--min-duration) 500ms
+		(--regex) PATTERN
+		(--body-contains) TEXT
+		(-l | --limit) N
+		(-s | --skip) N
+
+	History Detail Flags:
+		(--headers)
+		(--body)
+		(--request-body)
+		(--raw)
+		(--json)
+
+	History Diff Flags:
+		(--ignore) $.data[*].updatedAt (repeatable)
+		(--ignore-header) Date (repeatable)
+
+	History Replay Flags:
+		(--check-status)
+		(--session) NAME
+		(--reuse-session)
+		(--url) URL
+		(--host) [https://]HOST[:PORT]
+		(-H | --header) 'X-Api-Key: value' (repeatable)
+		(-d | --data) '{"key": "value"}'
+		(-t | --timeout) SECONDS
+		(-o | --output) /path/to/output/file.json
+		(-p | --print)
+		(-u | --auth) USER:PASS
+		(--auth-type) basic|digest
+		(--bearer) TOKEN
+		(--parallel) N
+		History filter flags, except --host
+
+	History Export Flags:
+		(--format) curl|har
+		(-o | --output) /path/to/output/file.sh
+		(--include-credentials)
+		History Flags, to export filtered records
+
+	History Clear Flags:
+		(-y | --yes)
+
+	History Prune Flags:
+		(--older-than) 30d
+		(--max-records) N
+		(--max-size) 500MB
+
+	History Rekey Flags:
+		(--passphrase-env) NAME
+		(--key-file) /path/to/key
+		(--decrypt)
+
+	Import Flags:
+		(--save) NAME
+		(-o | --output) /path/to/output/file.json
+		(-p | --print)
+		(--check-status)
+
+	Run Flags:
+		(--url) URL
+		(--host) [https://]HOST[:PORT]
+		(-H | --header) 'X-Api-Key: value' (repeatable)
+		(-d | --data) '{"key": "value"}'
+		(-t | --timeout) SECONDS
+		(-o | --output) /path/to/output/file.json
+		(-p | --print)
+		(-u | --auth) USER:PASS
+		(--auth-type) basic|digest
+		(--bearer) TOKEN
+		(--check-status)
+		(--env) NAME
+		(--extract) NAME=$.path|header:NAME|regex:RE (repeatable)
 
 	HTTP Flags:
 		(-j | --json)
 		(-c | --content-type) application/json
 		(-a | --accept) application/json
-		(-t | --timeout) 0 - 4294967295
+		(-t | --timeout) SECONDS
 		(-i | --input) /path/to/input/file.json
 		(-o | --output) /path/to/output/file.json
 		(-d | --data) '{"key": "value"}'
+		(-H | --header) 'X-Api-Key: value' (repeatable)
+		(-u | --auth) USER:PASS
+		(--auth-type) basic|digest
+		(--bearer) TOKEN
+		(--session) NAME
+		(--session-header) 'X-Api-Key: value' (repeatable)
+		(-k | --insecure)
+		(--no-body)
+		(--env) NAME
+		(--extract) NAME=$.path|header:NAME|regex:RE (repeatable)
 		(-p | --print)
+		(--check-status)
+
+	Exit codes:
+		0  Success
+		1  General error
+		2  Request timed out
+		4  HTTP 4xx response (with --check-status)
+		5  HTTP 5xx response (with --check-status)
+		6  Host name could not be resolved
+		7  Connection refused
+		8  TLS handshake or certificate failure
+
+	Replaying history:
+		Replay flags override the recorded URL, host, headers, body, timeout
+		or auth. -H 'Name:' removes a recorded header. The new record's
+		ReplayOf holds the ID of the record it replays. Given filter flags
+		instead of a record, every matching record is replayed oldest first,
+		up to --parallel at once, followed by a summary of status changes.
+
+	Saved requests:
+		save and history name save requests in ~/.gohttp/collections. A name
+		such as users/create puts a request in the users collection, a
+		folder. run NAME sends a saved request, run COLLECTION sends every
+		request in the collection in name order, and run alone lists them.
+
+	Environments and templates:
+		{{name}} in the URL, headers, --data, an --input file, --auth or
+		--bearer is replaced with a variable from the environment chosen with
+		--env NAME, read from ~/.gohttp/environments/NAME.json as
+		{"Variables": {"name": "value"}}. {{$env.NAME}} reads a process
+		environment variable, and {{$uuid}}, {{$timestamp}}, {{$isoTimestamp}}
+		and {{$randomInt MIN MAX}} generate values, and {{{{ writes a literal
+		{{. Without --env or --session nothing is templated. History keeps the
+		request as written alongside the resolved request.
+		--extract NAME=$.json.path, NAME=header:Location or NAME=regex:RE
+		saves a response value as a variable in the --session, or else the
+		--env environment, for later requests to use in templates.
+
+	Comparing history:
+		history diff compares two records, the first shown with - and the
+		second with +. JSON bodies are compared structurally, ignoring key
+		order. --ignore skips a path, where * or [*] matches any key or index
+		and ..key matches a key at any depth. Other text bodies are shown as
+		a unified diff.
+
+	Configuration:
+		Optional settings are read from ~/.gohttp/config.json. A retention
+		policy limits the history kept; it is enforced after each request is
+		saved, and history prune without flags applies it on demand. Deleting
+		or pruning records compacts history, so their data leaves the disk, and
+		history clear asks for confirmation unless given --yes.
+
+		History is redacted before it is written: secret headers, query and
+		form parameters and JSON fields are replaced with [redacted].
+		Redaction rules add header names, parameter names, JSON field names
+		and regular expressions to the built-in rules.
+
+		With Encryption enabled, history is encrypted with AES-256-GCM using
+		a key derived from a passphrase in $GOHTTP_HISTORY_PASSPHRASE (or the
+		variable named by PassphraseEnv) or from KeyFile. history rekey
+		re-encrypts all history with a new key, or decrypts it. An interrupted
+		rekey is finished, or undone, the next time history is opened.
+
+		Bodies of 256 bytes or more are gzipped into content-addressed blobs
+		in ~/.gohttp/history/blobs, so identical bodies are stored once.
+		--no-body skips saving a request's bodies. Smaller text bodies are
+		saved in records as strings, or as embedded JSON, marked by
+		BodyEncoding; binary bodies are saved as base64.
+
+		{"Retention": {"MaxAge": "30d", "MaxRecords": 5000, "MaxSize": "500MB"},
+		 "Redaction": {"Headers": ["X-Api-Key"], "Params": ["sig"],
+		  "JsonFields": ["pin"], "Patterns": ["sk-[A-Za-z0-9]+"]},
+		 "Encryption": {"Enabled": true, "PassphraseEnv": "GOHTTP_HISTORY_PASSPHRASE"}}
 */
 package main
 
@@ -56,7 +256,10 @@ import (
 func main() {
 	err := application.Start()
 	if err != nil {
-		log.Fatal(err.Error())
-		os.Exit(1)
+		log.Println(err.Error())
+		if exitErr, ok := err.(*application.ExitError); ok {
+			os.Exit(exitErr.Code)
+		}
+		os.Exit(application.ExitFailure)
 	}
 }